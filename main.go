@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"dedupe/photo"
 	"dedupe/tui"
+	"errors"
 	"flag"
 	"fmt"
 	tea "github.com/charmbracelet/bubbletea"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 )
 
 // teaMessenger is an adapter that allows a tea.Program to be used as a photo.Messenger.
@@ -28,12 +33,38 @@ func (m teaMessenger) Send(msg interface{}) {
 }
 
 func main() {
+	// Subcommands for the plan/apply workflow. Anything else is a regular run.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "plan":
+			runPlan(os.Args[2:])
+			return
+		case "apply":
+			runApply(os.Args[2:])
+			return
+		case "undo":
+			runUndo(os.Args[2:])
+			return
+		}
+	}
+
 	// Define command-line flags
 	moveFiles := flag.Bool("move", false, "Move files instead of copying them.")
 	logFile := flag.String("log", "duplicates.log", "Specify the log file location and name.")
+	dryRun := flag.Bool("dry-run", false, "Plan the run without copying or moving any files.")
+	planFile := flag.String("plan", "", "Write the dry-run plan to this file (implies -dry-run).")
+	journalFile := flag.String("journal", "journal.jsonl", "Record every copy and move in this journal for undo.")
+	useIndex := flag.Bool("index", true, "Keep a checksum index in the destination so interrupted runs can resume.")
+	scanLibrary := flag.Bool("library", true, "Deduplicate against files already organized in the destination.")
+	deterministic := flag.Bool("deterministic", false, "Inspect every file first so repeated runs choose the same originals.")
+	process := addProcessFlags(flag.CommandLine)
+	preserve := addPreserveFlags(flag.CommandLine)
 
 	flag.Usage = func() {
 		fmt.Println("Usage: dedupe [options] <source-dir> <dest-dir>")
+		fmt.Println("       dedupe plan [options] <source-dir> <dest-dir>")
+		fmt.Println("       dedupe apply [options] <plan-file>")
+		fmt.Println("       dedupe undo [options] <journal-file>")
 		fmt.Println("\nOptions:")
 		flag.PrintDefaults()
 	}
@@ -46,16 +77,333 @@ func main() {
 		os.Exit(1)
 	}
 
-	sourceDir := args[0]
-	destDir := args[1]
+	options := photo.Options{
+		MoveFiles:     *moveFiles,
+		Deterministic: *deterministic,
+		Preserve:      preserve.preserve(),
+	}
+	if err := process.apply(&options, args[0]); err != nil {
+		log.Fatalf("%s", err)
+	}
+	if *dryRun || *planFile != "" {
+		options.Plan = photo.NewPlan(args[0], args[1], *moveFiles)
+	} else {
+		options.Journal = openJournal(*journalFile, options.Hasher)
+		defer options.Journal.Close()
+	}
+	if *useIndex {
+		options.Index = openIndex(args[1], options.Hasher)
+		defer options.Index.Close()
+	}
+	organize(args[0], args[1], *logFile, *planFile, *scanLibrary, options)
+	if options.Journal != nil {
+		printRunID(options.Journal, *journalFile)
+	}
+}
+
+// runPlan implements `dedupe plan`, which writes a plan file without touching any files.
+func runPlan(arguments []string) {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	moveFiles := flags.Bool("move", false, "Plan to move files instead of copying them.")
+	planFile := flags.String("o", "plan.json", "Write the plan to this file.")
+	useIndex := flags.Bool("index", true, "Skip files already recorded in the destination's checksum index.")
+	scanLibrary := flags.Bool("library", true, "Deduplicate against files already organized in the destination.")
+	deterministic := flags.Bool("deterministic", false, "Inspect every file first so repeated runs choose the same originals.")
+	process := addProcessFlags(flags)
+	flags.Usage = func() {
+		fmt.Println("Usage: dedupe plan [options] <source-dir> <dest-dir>")
+		fmt.Println("\nOptions:")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(arguments)
+
+	args := flags.Args()
+	if len(args) < 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	// Plans may be applied from another working directory, so record absolute paths
+	sourceDir, err := filepath.Abs(args[0])
+	if err != nil {
+		log.Fatalf("Invalid source directory: %s", err)
+	}
+	destDir, err := filepath.Abs(args[1])
+	if err != nil {
+		log.Fatalf("Invalid destination directory: %s", err)
+	}
+
+	options := photo.Options{
+		MoveFiles:     *moveFiles,
+		Plan:          photo.NewPlan(sourceDir, destDir, *moveFiles),
+		Deterministic: *deterministic,
+	}
+	if err := process.apply(&options, sourceDir); err != nil {
+		log.Fatalf("%s", err)
+	}
+	if *useIndex {
+		options.Index = openIndex(destDir, options.Hasher)
+	}
+	organize(sourceDir, destDir, "", *planFile, *scanLibrary, options)
+}
+
+// runApply implements `dedupe apply`, which executes a previously written plan.
+func runApply(arguments []string) {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	logFile := flags.String("log", "duplicates.log", "Specify the log file location and name.")
+	journalFile := flags.String("journal", "journal.jsonl", "Record every copy and move in this journal for undo.")
+	useIndex := flags.Bool("index", true, "Record applied files in the destination's checksum index.")
+	preserve := addPreserveFlags(flags)
+	flags.Usage = func() {
+		fmt.Println("Usage: dedupe apply [options] <plan-file>")
+		fmt.Println("\nOptions:")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(arguments)
+
+	args := flags.Args()
+	if len(args) < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	plan, err := photo.LoadPlan(args[0])
+	if err != nil {
+		log.Fatalf("Error loading plan: %s", err)
+	}
+
+	// The plan's checksums are recorded as they were calculated at planning time
+	options := photo.Options{
+		Journal:  openJournal(*journalFile, plan.Hasher),
+		Preserve: preserve.preserve(),
+	}
+	defer options.Journal.Close()
+	if *useIndex {
+		options.Index = openIndex(plan.DestDir, plan.Hasher)
+		defer options.Index.Close()
+	}
+
+	state := photo.NewState(len(plan.Entries))
+	runTUI(state, func(ctx context.Context, messenger photo.Messenger) {
+		if err := photo.ApplyPlan(ctx, plan, *logFile, state, messenger, options); err != nil && !errors.Is(err, context.Canceled) {
+			log.Fatalf("Error applying plan: %s", err)
+		}
+	})
+	printRunID(options.Journal, *journalFile)
+}
+
+// runUndo implements `dedupe undo`, which reverses the operations recorded in a journal.
+func runUndo(arguments []string) {
+	flags := flag.NewFlagSet("undo", flag.ExitOnError)
+	runID := flags.String("run", "", "Only undo the run with this ID (default: all runs in the journal).")
+	flags.Usage = func() {
+		fmt.Println("Usage: dedupe undo [options] <journal-file>")
+		fmt.Println("\nOptions:")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(arguments)
+
+	args := flags.Args()
+	if len(args) < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	report, err := photo.Undo(args[0], *runID)
+	if err != nil {
+		log.Fatalf("Error undoing journal: %s", err)
+	}
+
+	fmt.Printf("Restored %d moved file(s), removed %d copied file(s).\n", report.Restored, report.Removed)
+	if len(report.Skipped) > 0 {
+		fmt.Printf("\n%d entry(s) could not be reversed:\n", len(report.Skipped))
+		for _, skip := range report.Skipped {
+			fmt.Printf("  %s -> %s: %s\n", skip.Entry.Destination, skip.Entry.Source, skip.Reason)
+		}
+		os.Exit(1)
+	}
+}
+
+// stringList is a flag that can be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// keeperFlags holds the command-line flags that configure the keeper policy.
+type keeperFlags struct {
+	rules    *string
+	priority *string
+	patterns stringList
+}
+
+// addKeeperFlags defines the keeper policy flags on flags.
+func addKeeperFlags(flags *flag.FlagSet) *keeperFlags {
+	keeper := &keeperFlags{
+		rules:    flags.String("keep", "", "Comma-separated rules for choosing which of several identical files is organized: priority, pattern, shortest, longest, oldest, newest or path."),
+		priority: flags.String("priority", "", "Comma-separated source directories, most preferred first, for -keep priority."),
+	}
+	flags.Var(&keeper.patterns, "prefer-name", "Regular expression for preferred file names, for -keep pattern. Can be given several times, most preferred first.")
+	return keeper
+}
 
+// policy builds the keeper policy from the flags. Relative priority directories are taken
+// to be relative to the source directory.
+func (k *keeperFlags) policy(sourceDir string) (photo.KeeperPolicy, error) {
+	var policy photo.KeeperPolicy
+	for _, name := range strings.Split(*k.rules, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		rule, err := photo.ParseKeeperRule(name)
+		if err != nil {
+			return photo.KeeperPolicy{}, fmt.Errorf("invalid -keep: %w", err)
+		}
+		policy.Rules = append(policy.Rules, rule)
+	}
+	for _, dir := range strings.Split(*k.priority, ",") {
+		if dir = strings.TrimSpace(dir); dir == "" {
+			continue
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(sourceDir, dir)
+		}
+		policy.Priority = append(policy.Priority, dir)
+	}
+	for _, expr := range k.patterns {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return photo.KeeperPolicy{}, fmt.Errorf("invalid -prefer-name: %w", err)
+		}
+		policy.Patterns = append(policy.Patterns, pattern)
+	}
+	return policy, nil
+}
+
+// preserveFlags holds the command-line flags that select the metadata kept by copies.
+type preserveFlags struct {
+	times  *bool
+	owner  *bool
+	xattrs *bool
+}
+
+// addPreserveFlags defines the metadata preservation flags on flags.
+func addPreserveFlags(flags *flag.FlagSet) *preserveFlags {
+	return &preserveFlags{
+		times:  flags.Bool("preserve-times", photo.DefaultPreserve.Times, "Give copies the access and modification times of their source."),
+		owner:  flags.Bool("preserve-owner", photo.DefaultPreserve.Owner, "Give copies the user and group of their source (usually requires root)."),
+		xattrs: flags.Bool("preserve-xattrs", photo.DefaultPreserve.Xattrs, "Copy extended attributes (Linux only)."),
+	}
+}
+
+// preserve returns the metadata selected by the flags.
+func (p *preserveFlags) preserve() photo.Preserve {
+	return photo.Preserve{Times: *p.times, Owner: *p.owner, Xattrs: *p.xattrs}
+}
+
+// hashUsage describes the -hash flag.
+const hashUsage = "Checksum algorithm: md5 (compatible with earlier runs), sha256 (for archival integrity records) or xxh3 (fastest)."
+
+// paranoidUsage describes the -paranoid flag.
+const paranoidUsage = "Compare every duplicate with its original byte for byte; files that only share the checksum are organized as unique."
+
+// duplicatesUsage describes the -duplicates flag.
+const duplicatesUsage = "How duplicates are placed in the duplicates directory: copy, hardlink or symlink to their original, or skip."
+
+// originalsUsage describes the -originals flag.
+const originalsUsage = "How originals are placed unless moved: copy, hardlink or reflink (btrfs and XFS); falls back to copy across file systems."
+
+// mediaInfoUsage describes the -mediainfo flag.
+const mediaInfoUsage = "Ask the mediainfo binary for the creation date of videos the built-in parsers cannot date."
+
+// processFlags holds the command-line flags shared by regular runs and `dedupe plan` that
+// decide how files are dated, compared and placed.
+type processFlags struct {
+	hash       *string
+	paranoid   *bool
+	duplicates *string
+	originals  *string
+	mediaInfo  *bool
+	keeper     *keeperFlags
+}
+
+// addProcessFlags defines the shared processing flags on flags.
+func addProcessFlags(flags *flag.FlagSet) *processFlags {
+	return &processFlags{
+		hash:       flags.String("hash", "md5", hashUsage),
+		paranoid:   flags.Bool("paranoid", false, paranoidUsage),
+		duplicates: flags.String("duplicates", "copy", duplicatesUsage),
+		originals:  flags.String("originals", "copy", originalsUsage),
+		mediaInfo:  flags.Bool("mediainfo", false, mediaInfoUsage),
+		keeper:     addKeeperFlags(flags),
+	}
+}
+
+// apply sets the options selected by the flags, or returns an error naming the flag with an
+// invalid value. Relative priority directories are taken to be relative to sourceDir.
+func (p *processFlags) apply(options *photo.Options, sourceDir string) error {
+	var err error
+	if options.Hasher, err = photo.ParseHasher(*p.hash); err != nil {
+		return fmt.Errorf("invalid -hash: %w", err)
+	}
+	if options.Duplicates, err = photo.ParseDuplicateMode(*p.duplicates); err != nil {
+		return fmt.Errorf("invalid -duplicates: %w", err)
+	}
+	if options.Originals, err = photo.ParseOriginalMode(*p.originals); err != nil {
+		return fmt.Errorf("invalid -originals: %w", err)
+	}
+	if options.Keeper, err = p.keeper.policy(sourceDir); err != nil {
+		return err
+	}
+	options.Paranoid = *p.paranoid
+	options.MediaInfo = *p.mediaInfo
+	return nil
+}
+
+// openIndex loads the persistent checksum index of the destination directory.
+func openIndex(destDir string, hasher photo.Hasher) *photo.Index {
+	index, err := photo.OpenIndex(filepath.Join(destDir, photo.IndexFileName), hasher)
+	if err != nil {
+		log.Fatalf("Error loading index: %s", err)
+	}
+	return index
+}
+
+// openJournal opens the journal for a run that will copy or move files.
+func openJournal(path string, hasher photo.Hasher) *photo.Journal {
+	journal, err := photo.OpenJournal(path, hasher)
+	if err != nil {
+		log.Fatalf("Error opening journal: %s", err)
+	}
+	return journal
+}
+
+// printRunID tells the user how to undo the run recorded in the journal at path.
+func printRunID(journal *photo.Journal, path string) {
+	fmt.Printf("Run %s recorded in %s; reverse it with: dedupe undo -run %s %s\n", journal.RunID(), path, journal.RunID(), path)
+}
+
+// organize validates the directories and processes the source directory with the progress TUI.
+// If scanLibrary is set, the destination is indexed first so existing files count as seen.
+// If options.Plan is set and planFile is not empty, the plan is written once processing completes
+// and its totals are printed.
+func organize(sourceDir, destDir, logFile, planFile string, scanLibrary bool, options photo.Options) {
 	// Validate directories
 	if _, err := os.Stat(sourceDir); os.IsNotExist(err) {
 		log.Fatalf("Source directory does not exist: %s", sourceDir)
 	}
 
-	if err := os.MkdirAll(destDir, 0755); err != nil {
-		log.Fatalf("Cannot create destination directory: %s", destDir)
+	// A dry run must not touch the destination, so it is only created for real runs
+	if options.Plan == nil {
+		if err := os.MkdirAll(destDir, 0755); err != nil {
+			log.Fatalf("Cannot create destination directory: %s", destDir)
+		}
 	}
 
 	// Calculate total files in the source directory
@@ -67,25 +415,77 @@ func main() {
 	// Initialize progress state
 	state := photo.NewState(totalFiles)
 
+	// Process files asynchronously
+	written := false
+	runTUI(state, func(ctx context.Context, messenger photo.Messenger) {
+		if scanLibrary {
+			library, err := photo.ScanLibrary(ctx, destDir, options.Index, options.Hasher, state, messenger)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			if err != nil {
+				log.Fatalf("Error indexing library: %s", err)
+			}
+			options.Library = library
+		}
+		err := photo.ProcessFiles(ctx, sourceDir, destDir, logFile, state, messenger, options)
+		if errors.Is(err, context.Canceled) {
+			return // The plan of a cancelled run is incomplete, so it is not written
+		}
+		if err != nil {
+			log.Fatalf("Error processing files: %s", err)
+		}
+		if options.Plan != nil && planFile != "" {
+			if err := options.Plan.WriteFile(planFile); err != nil {
+				log.Fatalf("Error writing plan: %s", err)
+			}
+			state.UpdateMessage(fmt.Sprintf("Plan written to %s", planFile))
+			messenger.Send(photo.ProgressTickMsg{})
+			written = true
+		}
+		//p.Quit() // Tell the TUI to exit gracefully once processing is complete.
+	})
+	if written {
+		fmt.Printf("Plan written to %s: %s\n", planFile, planSummary(options.Plan.Totals()))
+	}
+}
+
+// planSummary describes the totals of a plan in one line.
+func planSummary(totals photo.PlanTotals) string {
+	return fmt.Sprintf("%d to organize, %d without a date, %d duplicate(s), %d bytes to copy",
+		totals.Organize, totals.NoData, totals.Duplicates, totals.Bytes)
+}
+
+// runTUI starts the progress TUI for the given state and runs work in the background.
+// Quitting the TUI cancels the context passed to work, and runTUI only returns once work
+// has returned, so in-flight files are never cut off by the process exiting.
+func runTUI(state *photo.State, work func(ctx context.Context, messenger photo.Messenger)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// Start the TUI
-	p := tea.NewProgram(tui.New(state))
+	model := tui.New(state)
+	model.Cancel = cancel
+	p := tea.NewProgram(model)
 
 	// Create the messenger adapter.
 	messenger := teaMessenger{p: p}
 
-	// Process files asynchronously
+	done := make(chan struct{})
 	go func() {
-		options := photo.Options{
-			MoveFiles: *moveFiles,
-		}
-		if err := photo.ProcessFiles(sourceDir, destDir, *logFile, state, messenger, options); err != nil {
-			log.Fatalf("Error processing files: %s", err)
-		}
-		//p.Quit() // Tell the TUI to exit gracefully once processing is complete.
+		defer close(done)
+		work(ctx, messenger)
+		p.Send(tui.ProcessingDoneMsg{})
 	}()
 
 	// Start the TUI program
-	if _, err := p.Run(); err != nil {
+	_, err := p.Run()
+
+	// Wait for the workers to drain before returning
+	cancel()
+	<-done
+
+	if err != nil {
 		log.Fatalf("Error starting TUI: %s", err)
 	}
 }
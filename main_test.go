@@ -1,13 +1,17 @@
 package main
 
 import (
+	"crypto/md5"
 	"dedupe/photo"
 	"dedupe/tui"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -341,3 +345,142 @@ func TestCommandLineFlagParsing(t *testing.T) {
 		var _ photo.Messenger = messenger
 	})
 }
+
+// TestPlanSummary tests the line printed for the totals of a written plan
+func TestPlanSummary(t *testing.T) {
+	totals := photo.PlanTotals{Organize: 3, NoData: 1, Duplicates: 2, Bytes: 4096}
+	assert.Equal(t, "3 to organize, 1 without a date, 2 duplicate(s), 4096 bytes to copy", planSummary(totals))
+}
+
+// TestProcessFlags tests that the flags shared by runs and plans select the options and that
+// invalid values are rejected with the name of their flag
+func TestProcessFlags(t *testing.T) {
+	sourceDir := filepath.Join("photos", "unsorted")
+	tests := []struct {
+		name  string
+		args  []string
+		check func(t *testing.T, options photo.Options)
+		err   string
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, options photo.Options) {
+				assert.Equal(t, photo.HashMD5, options.Hasher)
+				assert.Equal(t, photo.LinkCopy, options.Duplicates)
+				assert.Equal(t, photo.LinkCopy, options.Originals)
+				assert.False(t, options.Paranoid)
+				assert.False(t, options.MediaInfo)
+				assert.Empty(t, options.Keeper.Rules)
+			},
+		},
+		{
+			name: "comparison",
+			args: []string{"-hash", "sha256", "-paranoid", "-mediainfo"},
+			check: func(t *testing.T, options photo.Options) {
+				assert.Equal(t, photo.HashSHA256, options.Hasher)
+				assert.True(t, options.Paranoid)
+				assert.True(t, options.MediaInfo)
+			},
+		},
+		{
+			name: "link modes",
+			args: []string{"-duplicates", "symlink", "-originals", "reflink"},
+			check: func(t *testing.T, options photo.Options) {
+				assert.Equal(t, photo.LinkSymlink, options.Duplicates)
+				assert.Equal(t, photo.LinkReflink, options.Originals)
+			},
+		},
+		{
+			name: "keeper policy",
+			args: []string{"-keep", "priority, pattern,oldest", "-priority", "Camera,/mnt/card", "-prefer-name", `^IMG_\d+`, "-prefer-name", "^DSC"},
+			check: func(t *testing.T, options photo.Options) {
+				assert.Equal(t, []photo.KeeperRule{photo.KeepPriority, photo.KeepPattern, photo.KeepOldest}, options.Keeper.Rules)
+				assert.Equal(t, []string{filepath.Join(sourceDir, "Camera"), "/mnt/card"}, options.Keeper.Priority)
+				if assert.Len(t, options.Keeper.Patterns, 2) {
+					assert.Equal(t, `^IMG_\d+`, options.Keeper.Patterns[0].String())
+					assert.Equal(t, "^DSC", options.Keeper.Patterns[1].String())
+				}
+			},
+		},
+		{name: "unknown hash", args: []string{"-hash", "crc32"}, err: "invalid -hash"},
+		{name: "unknown duplicate mode", args: []string{"-duplicates", "reflink"}, err: "invalid -duplicates"},
+		{name: "unknown original mode", args: []string{"-originals", "symlink"}, err: "invalid -originals"},
+		{name: "unknown keeper rule", args: []string{"-keep", "path,largest"}, err: "invalid -keep"},
+		{name: "invalid name pattern", args: []string{"-prefer-name", "IMG_("}, err: "invalid -prefer-name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			process := addProcessFlags(flags)
+			assert.NoError(t, flags.Parse(tt.args))
+
+			var options photo.Options
+			err := process.apply(&options, sourceDir)
+			if tt.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tt.err)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			tt.check(t, options)
+		})
+	}
+}
+
+// TestPreserveFlags tests that each kind of metadata can be turned on and off
+func TestPreserveFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected photo.Preserve
+	}{
+		{"defaults", nil, photo.DefaultPreserve},
+		{"without times", []string{"-preserve-times=false"}, photo.Preserve{Xattrs: true}},
+		{"with owner", []string{"-preserve-owner"}, photo.Preserve{Times: true, Owner: true, Xattrs: true}},
+		{"without xattrs", []string{"-preserve-xattrs=false"}, photo.Preserve{Times: true}},
+		{"nothing", []string{"-preserve-times=false", "-preserve-xattrs=false"}, photo.Preserve{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			preserve := addPreserveFlags(flags)
+			assert.NoError(t, flags.Parse(tt.args))
+			assert.Equal(t, tt.expected, preserve.preserve())
+		})
+	}
+}
+
+// TestRunUndo tests that `dedupe undo` removes the copies recorded in a journal
+func TestRunUndo(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "dedupe-test-undo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	source := filepath.Join(tempDir, "photo.jpg")
+	copied := filepath.Join(tempDir, "organized", "photo.jpg")
+	assert.NoError(t, os.WriteFile(source, []byte("Photo"), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Dir(copied), 0755))
+	assert.NoError(t, os.WriteFile(copied, []byte("Photo"), 0644))
+
+	// A journal as a run that copied photo.jpg into organized/ would have written it
+	line, err := json.Marshal(photo.JournalEntry{
+		RunID:       "run",
+		Operation:   photo.OperationCopy,
+		Source:      source,
+		Destination: copied,
+		Checksum:    fmt.Sprintf("%x", md5.Sum([]byte("Photo"))),
+	})
+	assert.NoError(t, err)
+	journalPath := filepath.Join(tempDir, "journal.jsonl")
+	assert.NoError(t, os.WriteFile(journalPath, append(line, '\n'), 0644))
+
+	runUndo([]string{"-run", "run", journalPath})
+
+	_, err = os.Stat(copied)
+	assert.True(t, os.IsNotExist(err), "Expected the copy to be removed")
+	_, err = os.Stat(source)
+	assert.NoError(t, err, "Expected the source to be kept")
+}
@@ -0,0 +1,269 @@
+package photo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrStalePlan is returned by ApplyPlan when source files changed after the plan was created.
+var ErrStalePlan = errors.New("source files changed since the plan was created")
+
+// maxReportedChanges limits how many changed files are listed in an ErrStalePlan error.
+const maxReportedChanges = 5
+
+// ApplyPlan executes a plan produced by a dry run. The plan is validated and every source
+// file is checked against the size, modification time and checksum recorded at planning
+// time; if anything changed, no file is touched and an error wrapping ErrStalePlan is returned.
+// Whether files are moved or linked and the checksum algorithm are taken from the plan;
+// options.MoveFiles, options.Plan, options.Hasher, options.Duplicates and options.Originals are
+// ignored, but options.Index must use the plan's algorithm.
+// Cancelling ctx stops handing out entries; entries already being applied are finished.
+func ApplyPlan(ctx context.Context, plan *Plan, logFilePath string, state *State, messenger Messenger, options Options) error {
+	state.SetDryRun(false)
+
+	if err := validatePlan(plan); err != nil {
+		return err
+	}
+	if options.Index != nil && !options.Index.Hasher().matches(plan.Hasher) {
+		return fmt.Errorf("index uses %s checksums, but the plan uses %s", options.Index.Hasher(), plan.Hasher)
+	}
+
+	state.UpdateMessage(fmt.Sprintf("Verifying %d Planned Files", len(plan.Entries)))
+	messenger.Send(ProgressTickMsg{})
+
+	if err := verifySources(ctx, plan.Entries, plan.Hasher, state); err != nil {
+		return err
+	}
+
+	// Open the log file for duplicates
+	logFile, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer logFile.Close()
+
+	// Copies of a run that crashed never made it into place
+	if plan.DestDir != "" {
+		if removed, err := removeTempFiles(plan.DestDir); err != nil {
+			return err
+		} else if removed > 0 {
+			_, _ = fmt.Fprintf(logFile, "Removed %d temporary file(s) left by an interrupted run\n", removed)
+		}
+	}
+
+	options.Hasher = plan.Hasher
+	options.Duplicates = plan.Duplicates
+	options.Originals = plan.Originals
+	applyEntries(ctx, plan.Entries, plan.MoveFiles, logFile, "Applying plan ...", state, messenger, options)
+
+	if ctx.Err() != nil {
+		state.UpdateMessage("Cancelled")
+		messenger.Send(ProgressTickMsg{})
+		return ctx.Err()
+	}
+
+	state.UpdateMessage("Plan Applied")
+	messenger.Send(ProgressTickMsg{})
+	return nil
+}
+
+// applyEntries applies entries from a pool of workers, updating state with message as entries
+// complete. Duplicates that link to their original are applied once all other entries are in
+// place. Duplicates that were placed and moves that left their source behind are logged
+// afterwards in the order of entries, so the log does not depend on worker scheduling.
+// Cancelling ctx stops handing out entries; entries already being applied are finished.
+func applyEntries(ctx context.Context, entries []PlanEntry, moveFiles bool, logFile io.Writer, message string, state *State, messenger Messenger, options Options) {
+	applied := make([]bool, len(entries))
+	errs := make([]error, len(entries))
+
+	var originals, duplicates []int
+	for i, entry := range entries {
+		if entry.Action == ActionDuplicate && options.Duplicates.links() {
+			duplicates = append(duplicates, i)
+		} else {
+			originals = append(originals, i)
+		}
+	}
+	applyBatch(ctx, entries, originals, applied, errs, moveFiles, message, state, messenger, options)
+	applyBatch(ctx, entries, duplicates, applied, errs, moveFiles, message, state, messenger, options)
+
+	for i, entry := range entries {
+		if errors.Is(errs[i], errSourceNotRemoved) {
+			_, _ = fmt.Fprintf(logFile, "Source not removed: %v\n", errs[i])
+		}
+		switch {
+		case !applied[i]:
+		case entry.Action == ActionDuplicate:
+			_, _ = fmt.Fprintf(logFile, "Duplicate detected: %s (duplicate of: %s)\n", entry.Source, entry.DuplicateOf)
+		case entry.CollidesWith != "":
+			logCollision(logFile, entry)
+		}
+	}
+}
+
+// applyBatch applies the entries at indexes from a pool of workers, marks the entries that were
+// placed in applied and keeps the errors in errs. The caller checks ctx for cancellation.
+func applyBatch(ctx context.Context, entries []PlanEntry, indexes []int, applied []bool, errs []error, moveFiles bool, message string, state *State, messenger Messenger, options Options) {
+	_ = forEach(ctx, indexes, state, func(i int) {
+		err := applyEntry(entries[i], moveFiles, state, options)
+		if err != nil {
+			state.IncrementError()
+			errs[i] = err
+		}
+		applied[i] = err == nil || errors.Is(err, errSourceNotRemoved)
+		state.IncrementProcessed()
+		if !state.IsPaused() {
+			state.UpdateMessage(message)
+		}
+		messenger.Send(ProgressTickMsg{})
+	})
+}
+
+// validatePlan checks a (possibly hand-edited) plan for entries that cannot be executed safely,
+// including duplicates whose original is neither planned nor already in place.
+func validatePlan(plan *Plan) error {
+	sources := make(map[string]struct{}, len(plan.Entries))
+	destinations := make(map[string]struct{}, len(plan.Entries))
+	originals := make(map[string]struct{}, len(plan.Entries)) // Destinations of the entries that are not duplicates
+
+	for _, entry := range plan.Entries {
+		switch entry.Action {
+		case ActionOrganize, ActionNoData, ActionDuplicate:
+		default:
+			return fmt.Errorf("unknown action %q for %s", entry.Action, entry.Source)
+		}
+		if entry.Destination == "" {
+			return fmt.Errorf("missing destination for %s", entry.Source)
+		}
+		if _, exists := sources[entry.Source]; exists {
+			return fmt.Errorf("source %s is planned more than once", entry.Source)
+		}
+		sources[entry.Source] = struct{}{}
+
+		destination := filepath.Clean(entry.Destination)
+		if _, exists := destinations[destination]; exists {
+			return fmt.Errorf("destination %s is planned more than once", entry.Destination)
+		}
+		destinations[destination] = struct{}{}
+
+		if _, err := os.Stat(destination); err == nil {
+			return fmt.Errorf("destination %s already exists", entry.Destination)
+		}
+		if entry.Action != ActionDuplicate {
+			originals[destination] = struct{}{}
+		}
+	}
+
+	// A duplicate must point at a file that will be, or already is, in place
+	for _, entry := range plan.Entries {
+		if entry.Action != ActionDuplicate {
+			continue
+		}
+		if entry.DuplicateOf == "" {
+			return fmt.Errorf("duplicate %s does not name its original", entry.Source)
+		}
+		if _, planned := originals[filepath.Clean(entry.DuplicateOf)]; planned {
+			continue
+		}
+		if info, err := os.Stat(entry.DuplicateOf); err != nil || !info.Mode().IsRegular() {
+			return fmt.Errorf("original %s of duplicate %s is neither planned nor an existing file", entry.DuplicateOf, entry.Source)
+		}
+	}
+	return nil
+}
+
+// verifySources compares every planned source file with what was recorded at planning time,
+// holding on while state is paused.
+func verifySources(ctx context.Context, entries []PlanEntry, hasher Hasher, state *State) error {
+	var (
+		changed []string
+		lock    sync.Mutex
+	)
+
+	err := forEach(ctx, entries, state, func(entry PlanEntry) {
+		if reason := sourceChange(entry, hasher); reason != "" {
+			lock.Lock()
+			changed = append(changed, fmt.Sprintf("%s (%s)", entry.Source, reason))
+			lock.Unlock()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	reported := changed
+	if len(reported) > maxReportedChanges {
+		reported = reported[:maxReportedChanges]
+	}
+	return fmt.Errorf("%w: %d file(s), including %s", ErrStalePlan, len(changed), strings.Join(reported, ", "))
+}
+
+// sourceChange returns a short reason if the source of an entry no longer matches the plan,
+// or an empty string if it is unchanged.
+func sourceChange(entry PlanEntry, hasher Hasher) string {
+	file, err := os.Open(entry.Source)
+	if err != nil {
+		return "missing"
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "unreadable"
+	}
+	if info.Size() != entry.Size {
+		return "size changed"
+	}
+	if !info.ModTime().Equal(entry.ModTime) {
+		return "modification time changed"
+	}
+
+	checksum, err := calculateChecksum(file, hasher)
+	if err != nil {
+		return "unreadable"
+	}
+	if checksum != entry.Checksum {
+		return "checksum changed"
+	}
+	return ""
+}
+
+// applyEntry executes a single plan entry.
+func applyEntry(entry PlanEntry, moveFiles bool, state *State, options Options) error {
+	if err := os.MkdirAll(filepath.Dir(entry.Destination), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", entry.Destination, err)
+	}
+
+	if entry.Action == ActionDuplicate {
+		// Duplicates are never moved, matching ProcessFiles
+		if err := writeDuplicate(entry, options); err != nil {
+			return err
+		}
+		state.IncrementDuplicates()
+		return nil
+	}
+
+	// Another process may have taken the planned name since the plan was validated
+	options.MoveFiles = moveFiles
+	placedPath, err := placeOriginal(entry, options)
+	if err != nil && !errors.Is(err, errSourceNotRemoved) {
+		return err
+	}
+	entry.Destination = placedPath
+
+	switch entry.Action {
+	case ActionNoData:
+		state.IncrementNoData()
+	default:
+		state.IncrementUnique()
+	}
+	return recordOriginal(entry, err, options)
+}
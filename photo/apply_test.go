@@ -0,0 +1,274 @@
+package photo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createPlannedTree creates a source directory with a duplicate pair and a unique file,
+// and returns a dry-run plan for it.
+func createPlannedTree(t *testing.T, tempDir string) (string, string, *Plan) {
+	t.Helper()
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	files := map[string]string{
+		"a/same.txt": "Same content",
+		"b/same.txt": "Same content",
+		"unique.txt": "Unique content",
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", name, err)
+		}
+	}
+
+	plan := NewPlan(srcDir, destDir, false)
+	err := ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "plan.log"), NewState(len(files)), NewMockMessenger(), Options{Plan: plan})
+	if err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+
+	// Round-trip the plan through a file, as the plan/apply commands do
+	planPath := filepath.Join(tempDir, "plan.json")
+	if err := plan.WriteFile(planPath); err != nil {
+		t.Fatalf("Failed to write plan: %v", err)
+	}
+	loaded, err := LoadPlan(planPath)
+	if err != nil {
+		t.Fatalf("Failed to load plan: %v", err)
+	}
+	return srcDir, destDir, loaded
+}
+
+// TestApplyPlan tests that applying a plan creates exactly the planned destinations
+func TestApplyPlan(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-apply-plan")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	_, _, plan := createPlannedTree(t, tempDir)
+
+	state := NewState(len(plan.Entries))
+	if err := ApplyPlan(context.Background(), plan, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), Options{}); err != nil {
+		t.Fatalf("ApplyPlan returned an error: %v", err)
+	}
+
+	for _, entry := range plan.Entries {
+		if _, err := os.Stat(entry.Destination); err != nil {
+			t.Errorf("Expected %s to be created for %s: %v", entry.Destination, entry.Source, err)
+		}
+	}
+	if state.GetProcessedCount() != len(plan.Entries) {
+		t.Errorf("Expected %d processed entries, got %d", len(plan.Entries), state.GetProcessedCount())
+	}
+	if state.GetDuplicateCount() != 1 || state.GetNoDataCount() != 2 {
+		t.Errorf("Expected 1 duplicate and 2 no-data files, got %d and %d", state.GetDuplicateCount(), state.GetNoDataCount())
+	}
+}
+
+// TestApplyPlanEdited tests that hand-edited destinations and dropped entries are honoured
+func TestApplyPlanEdited(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-apply-plan-edited")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	_, destDir, plan := createPlannedTree(t, tempDir)
+
+	// Drop the duplicate and send the remaining files somewhere else
+	var edited []PlanEntry
+	for _, entry := range plan.Entries {
+		if entry.Action == ActionDuplicate {
+			continue
+		}
+		entry.Destination = filepath.Join(destDir, "custom", filepath.Base(entry.Source))
+		edited = append(edited, entry)
+	}
+	plan.Entries = edited
+
+	if err := ApplyPlan(context.Background(), plan, filepath.Join(tempDir, "test.log"), NewState(len(edited)), NewMockMessenger(), Options{}); err != nil {
+		t.Fatalf("ApplyPlan returned an error: %v", err)
+	}
+
+	for _, entry := range edited {
+		if _, err := os.Stat(entry.Destination); err != nil {
+			t.Errorf("Expected overridden destination %s to exist: %v", entry.Destination, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "duplicates")); !os.IsNotExist(err) {
+		t.Errorf("Expected dropped duplicate not to be copied")
+	}
+}
+
+// TestApplyPlanStale tests that a plan is refused when a source file changed after planning
+func TestApplyPlanStale(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-apply-plan-stale")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir, destDir, plan := createPlannedTree(t, tempDir)
+
+	// Same size, different content: only the checksum reveals the change
+	changed := filepath.Join(srcDir, "unique.txt")
+	info, err := os.Stat(changed)
+	if err != nil {
+		t.Fatalf("Failed to stat source file: %v", err)
+	}
+	if err := os.WriteFile(changed, []byte("Altered content"[:info.Size()]), 0644); err != nil {
+		t.Fatalf("Failed to modify source file: %v", err)
+	}
+	if err := os.Chtimes(changed, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("Failed to restore modification time: %v", err)
+	}
+
+	err = ApplyPlan(context.Background(), plan, filepath.Join(tempDir, "test.log"), NewState(len(plan.Entries)), NewMockMessenger(), Options{})
+	if !errors.Is(err, ErrStalePlan) {
+		t.Fatalf("Expected ErrStalePlan, got %v", err)
+	}
+	if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+		t.Errorf("Expected no files to be touched when the plan is stale")
+	}
+}
+
+// TestApplyPlanCancel tests that a cancelled context stops a plan before any entry is applied
+func TestApplyPlanCancel(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-apply-plan-cancel")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	_, destDir, plan := createPlannedTree(t, tempDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	state := NewState(len(plan.Entries))
+	err = ApplyPlan(ctx, plan, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), Options{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if state.GetProcessedCount() != 0 {
+		t.Errorf("Expected no entries to be applied, got %d", state.GetProcessedCount())
+	}
+	if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+		t.Errorf("Expected no files to be touched when cancelled")
+	}
+}
+
+// TestValidatePlan tests that conflicting hand edits are rejected
+func TestValidatePlan(t *testing.T) {
+	plan := NewPlan("src", "dest", false)
+	plan.Entries = []PlanEntry{
+		{Source: "src/a.jpg", Action: ActionOrganize, Destination: "dest/x.jpg"},
+		{Source: "src/b.jpg", Action: ActionOrganize, Destination: "dest/x.jpg"},
+	}
+	if err := validatePlan(plan); err == nil {
+		t.Errorf("Expected an error for a destination planned twice")
+	}
+
+	plan.Entries = []PlanEntry{
+		{Source: "src/a.jpg", Action: "delete", Destination: "dest/x.jpg"},
+	}
+	if err := validatePlan(plan); err == nil {
+		t.Errorf("Expected an error for an unknown action")
+	}
+
+	plan.Entries = []PlanEntry{
+		{Source: "src/a.jpg", Action: ActionOrganize, Destination: "dest/duplicates/a.jpg"},
+		{Source: "src/b.jpg", Action: ActionDuplicate, Destination: "dest/2020/01/02/b.jpg"},
+	}
+	if err := validatePlan(plan); err == nil {
+		t.Errorf("Expected an error for a duplicate without an original")
+	}
+
+	plan.Entries[1].DuplicateOf = "dest/2020/01/02/a.jpg"
+	if err := validatePlan(plan); err == nil {
+		t.Errorf("Expected an error for a duplicate of a file that is neither planned nor in place")
+	}
+
+	plan.Entries[1].DuplicateOf = "dest/duplicates/a.jpg"
+	if err := validatePlan(plan); err != nil {
+		t.Errorf("Expected a duplicate of a planned original to be accepted, got %v", err)
+	}
+}
+
+// TestApplyPlanChosenOriginal tests that swapping only the actions of an original and its
+// duplicate is refused, while the documented edit keeps the chosen file as the original
+func TestApplyPlanChosenOriginal(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-apply-plan-chosen")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	_, destDir, plan := createPlannedTree(t, tempDir)
+
+	var original, duplicate *PlanEntry
+	for i := range plan.Entries {
+		if plan.Entries[i].Action == ActionDuplicate {
+			duplicate = &plan.Entries[i]
+		}
+	}
+	for i := range plan.Entries {
+		if plan.Entries[i].Destination == duplicate.DuplicateOf {
+			original = &plan.Entries[i]
+		}
+	}
+
+	original.Action, duplicate.Action = duplicate.Action, original.Action
+	err = ApplyPlan(context.Background(), plan, filepath.Join(tempDir, "test.log"), NewState(len(plan.Entries)), NewMockMessenger(), Options{})
+	if err == nil {
+		t.Fatalf("Expected a plan with swapped actions only to be refused")
+	}
+	if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+		t.Errorf("Expected no files to be touched when the plan is refused")
+	}
+
+	original.Destination, duplicate.Destination = duplicate.Destination, original.Destination
+	original.DuplicateOf, duplicate.DuplicateOf = duplicate.Destination, ""
+	if err := ApplyPlan(context.Background(), plan, filepath.Join(tempDir, "test.log"), NewState(len(plan.Entries)), NewMockMessenger(), Options{}); err != nil {
+		t.Fatalf("ApplyPlan returned an error: %v", err)
+	}
+	for _, entry := range []*PlanEntry{original, duplicate} {
+		if _, err := os.Stat(entry.Destination); err != nil {
+			t.Errorf("Expected %s to be placed at %s: %v", entry.Source, entry.Destination, err)
+		}
+	}
+}
+
+// TestApplyEntryFailed tests that an entry that cannot be placed is not counted as organized
+func TestApplyEntryFailed(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-apply-entry-failed")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	state := NewState(2)
+	for _, action := range []Action{ActionOrganize, ActionNoData} {
+		entry := PlanEntry{
+			Source:      filepath.Join(tempDir, "missing.jpg"),
+			Action:      action,
+			Destination: filepath.Join(tempDir, "dest", string(action), "missing.jpg"),
+		}
+		if err := applyEntry(entry, false, state, Options{}); err == nil {
+			t.Errorf("Expected an error for the missing source of a %s entry", action)
+		}
+	}
+	if state.GetUniqueFileCount() != 0 || state.GetNoDataCount() != 0 {
+		t.Errorf("Expected no organized or no-data files, got %d and %d", state.GetUniqueFileCount(), state.GetNoDataCount())
+	}
+}
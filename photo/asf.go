@@ -0,0 +1,63 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// ASF object GUIDs as stored in the file, with their first three fields little-endian.
+var (
+	// 75B22630-668E-11CF-A6D9-00AA0062CE6C
+	asfHeaderGUID = []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11, 0xA6, 0xD9, 0x00, 0xAA, 0x00, 0x62, 0xCE, 0x6C}
+	// 8CABDCA1-A947-11CF-8EE4-00C00C205365
+	asfFilePropertiesGUID = []byte{0xA1, 0xDC, 0xAB, 0x8C, 0x47, 0xA9, 0xCF, 0x11, 0x8E, 0xE4, 0x00, 0xC0, 0x0C, 0x20, 0x53, 0x65}
+)
+
+// asfEpoch is the origin of ASF dates, which count 100-nanosecond intervals like Windows FILETIME.
+var asfEpoch = time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// getASFCreationDate reads the creation date of a WMV file of the given size from the file
+// properties object in its header.
+func getASFCreationDate(r io.ReaderAt, size int64) (time.Time, error) {
+	var header [30]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil || !bytes.Equal(header[:16], asfHeaderGUID) {
+		return time.Time{}, errors.New("not an ASF file")
+	}
+
+	end := int64(binary.LittleEndian.Uint64(header[16:24]))
+	if end < 0 || end > size {
+		end = size
+	}
+	count := binary.LittleEndian.Uint32(header[24:28])
+	offset := int64(len(header))
+	for i := uint32(0); i < count && offset+24 <= end; i++ {
+		var object [24]byte
+		if _, err := r.ReadAt(object[:], offset); err != nil {
+			return time.Time{}, err
+		}
+		objectSize := int64(binary.LittleEndian.Uint64(object[16:24]))
+		if objectSize < int64(len(object)) || objectSize > end-offset {
+			return time.Time{}, errors.New("invalid ASF header object")
+		}
+
+		// The creation date follows the file ID and the file size
+		if bytes.Equal(object[:16], asfFilePropertiesGUID) && objectSize >= 24+16+8+8 {
+			var data [8]byte
+			if _, err := r.ReadAt(data[:], offset+24+16+8); err != nil {
+				return time.Time{}, err
+			}
+			intervals := binary.LittleEndian.Uint64(data[:])
+			if intervals == 0 { // Unset, as for live broadcasts
+				return time.Time{}, errNoVideoDate
+			}
+			seconds := int64(intervals / 10_000_000)
+			nanoseconds := int64(intervals%10_000_000) * 100
+			return time.Unix(asfEpoch.Unix()+seconds, nanoseconds).UTC(), nil
+		}
+		offset += objectSize
+	}
+	return time.Time{}, errNoVideoDate
+}
@@ -0,0 +1,63 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// asfFile returns a WMV file whose file properties object records a creation date of the given
+// number of 100-nanosecond intervals since 1601
+func asfFile(intervals uint64) []byte {
+	object := func(guid []byte, payload []byte) []byte {
+		var b bytes.Buffer
+		b.Write(guid)
+		binary.Write(&b, binary.LittleEndian, uint64(24+len(payload)))
+		b.Write(payload)
+		return b.Bytes()
+	}
+
+	var properties bytes.Buffer
+	properties.Write(make([]byte, 16))                            // File ID
+	binary.Write(&properties, binary.LittleEndian, uint64(12345)) // File size
+	binary.Write(&properties, binary.LittleEndian, intervals)     // Creation date
+	properties.Write(make([]byte, 56))                            // The rest is not read
+
+	// Another header object comes first, as the content description often does
+	objects := bytes.Join([][]byte{
+		object(bytes.Repeat([]byte{0x11}, 16), make([]byte, 40)),
+		object(asfFilePropertiesGUID, properties.Bytes()),
+	}, nil)
+
+	var header bytes.Buffer
+	header.Write(asfHeaderGUID)
+	binary.Write(&header, binary.LittleEndian, uint64(30+len(objects)))
+	binary.Write(&header, binary.LittleEndian, uint32(2)) // Number of header objects
+	header.Write([]byte{0x01, 0x02})                      // Reserved
+	header.Write(objects)
+	header.Write(bytes.Repeat([]byte{0x42}, 4096)) // Data object
+	return header.Bytes()
+}
+
+// TestGetASFCreationDate tests the creation date read from synthetic WMV files
+func TestGetASFCreationDate(t *testing.T) {
+	created := time.Date(2007, 10, 11, 12, 13, 14, 500_000_000, time.UTC)
+	intervals := uint64(created.Unix()-asfEpoch.Unix())*10_000_000 + 5_000_000
+
+	content := asfFile(intervals)
+	date, err := getASFCreationDate(bytes.NewReader(content), int64(len(content)))
+	if err != nil || !date.Equal(created) {
+		t.Errorf("Expected %v, got %v, %v", created, date, err)
+	}
+
+	content = asfFile(0)
+	if _, err := getASFCreationDate(bytes.NewReader(content), int64(len(content))); !errors.Is(err, errNoVideoDate) {
+		t.Errorf("Expected errNoVideoDate for an unset date, got %v", err)
+	}
+	content = []byte("This is not a valid video file")
+	if date, err := getASFCreationDate(bytes.NewReader(content), int64(len(content))); err == nil {
+		t.Errorf("Expected an error for an invalid file, got %v", date)
+	}
+}
@@ -0,0 +1,130 @@
+package photo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tempFilePrefix starts the name of every temporary file written into the destination. The
+// leading dot keeps such files out of file counts and library scans.
+const tempFilePrefix = ".dedupe-tmp-"
+
+// staleTempFileAge is how long a temporary file must have gone unwritten before it is taken
+// for a leftover of a crashed run. Younger ones may belong to another run that is still copying.
+const staleTempFileAge = time.Hour
+
+// createTempFile creates a temporary file in dir, next to the file it will be renamed to, so
+// that the rename cannot cross file systems.
+func createTempFile(dir string) (*os.File, error) {
+	return os.CreateTemp(dir, tempFilePrefix+"*")
+}
+
+// finishTempFile applies mode to a temporary file, flushes it to disk and closes it.
+func finishTempFile(file *os.File, mode os.FileMode) error {
+	err := file.Chmod(mode)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// renameTempFile atomically moves a finished temporary file to dest and flushes the directory,
+// so that after a crash dest is either missing or complete. An existing dest is left alone and
+// an error matching fs.ErrExist is returned.
+func renameTempFile(tempPath, dest string) error {
+	if err := renameNoReplace(tempPath, dest); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(dest))
+}
+
+// renameNoReplace renames oldPath to newPath like os.Rename, except that it fails with an error
+// matching fs.ErrExist instead of replacing newPath. Where the kernel cannot rename exclusively,
+// newPath is claimed with a hardlink, or else with an empty file created with O_EXCL that the
+// rename then replaces.
+func renameNoReplace(oldPath, newPath string) error {
+	err := renameExclusive(oldPath, newPath)
+	if !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+
+	err = os.Link(oldPath, newPath)
+	if err == nil {
+		if err := os.Remove(oldPath); err != nil {
+			os.Remove(newPath)
+			return err
+		}
+		return nil
+	}
+	if errors.Is(err, fs.ErrExist) || errors.Is(err, fs.ErrNotExist) || isCrossDevice(err) {
+		return err
+	}
+
+	// The file system has no hardlinks, as FAT does not
+	placeholder, err := os.OpenFile(newPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	placeholder.Close()
+	if err := os.Rename(oldPath, newPath); err != nil {
+		os.Remove(newPath)
+		return err
+	}
+	return nil
+}
+
+// syncDir flushes a directory entry change, such as a rename, to disk.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// removeTempFiles removes the temporary files that runs interrupted by a crash left behind in
+// destDir, returning how many were removed. Files modified within staleTempFileAge are left
+// alone, since another run may still be writing them.
+func removeTempFiles(destDir string) (int, error) {
+	cutoff := time.Now().Add(-staleTempFileAge)
+	removed := 0
+	err := filepath.WalkDir(destDir, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == destDir {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasPrefix(d.Name(), tempFilePrefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			return nil // Renamed into place by another run meanwhile
+		}
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to remove temporary files: %w", err)
+	}
+	return removed, nil
+}
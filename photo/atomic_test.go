@@ -0,0 +1,262 @@
+package photo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestCopyFileAtomic tests that copies appear complete or not at all
+func TestCopyFileAtomic(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-copy-file-atomic")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcPath := filepath.Join(tempDir, "source.jpg")
+	destPath := filepath.Join(tempDir, "dest", "photo.jpg")
+	createLibrary(t, tempDir, map[string]string{
+		"source.jpg":                          "New content",
+		filepath.Join("dest", "photo.jpg"):    "Old content",
+		filepath.Join("dir.jpg", "child.jpg"): "A directory cannot be copied",
+	})
+
+	// A failed copy leaves the destination alone and no temporary file behind
+	if err := copyFile(filepath.Join(tempDir, "dir.jpg"), destPath, Preserve{}); err == nil {
+		t.Errorf("Expected an error when copying a directory")
+	}
+	if content, _ := os.ReadFile(destPath); string(content) != "Old content" {
+		t.Errorf("Expected the destination to be untouched, got %q", content)
+	}
+
+	// An existing destination is never replaced
+	if err := copyFile(srcPath, destPath, Preserve{}); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Expected an already-exists error, got %v", err)
+	}
+	if content, _ := os.ReadFile(destPath); string(content) != "Old content" {
+		t.Errorf("Expected the destination to be untouched, got %q", content)
+	}
+
+	os.Remove(destPath)
+	if err := copyFile(srcPath, destPath, Preserve{}); err != nil {
+		t.Fatalf("copyFile returned an error: %v", err)
+	}
+	if content, _ := os.ReadFile(destPath); string(content) != "New content" {
+		t.Errorf("Expected the copy in place, got %q", content)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(destPath))
+	if err != nil {
+		t.Fatalf("Failed to read destination directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the copy in the destination directory, found %d files", len(entries))
+	}
+}
+
+// TestRemoveTempFiles tests that only temporary files left by interrupted runs are removed
+func TestRemoveTempFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-remove-temp-files")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	leftovers := []string{
+		filepath.Join("2020", "01", "02", tempFilePrefix+"123"),
+		filepath.Join("nodata", tempFilePrefix+"456"),
+	}
+	kept := []string{
+		filepath.Join("2020", "01", "02", "photo_6cd3556d.jpg"),
+		filepath.Join("2020", "01", "02", tempFilePrefix+"789"), // Still being written by another run
+		IndexFileName,
+	}
+	files := make(map[string]string)
+	for _, name := range append(leftovers, kept...) {
+		files[name] = "content"
+	}
+	createLibrary(t, tempDir, files)
+	ageTempFiles(t, tempDir, leftovers...)
+
+	removed, err := removeTempFiles(tempDir)
+	if err != nil {
+		t.Fatalf("removeTempFiles returned an error: %v", err)
+	}
+	if removed != len(leftovers) {
+		t.Errorf("Expected %d removed files, got %d", len(leftovers), removed)
+	}
+	for _, name := range leftovers {
+		if _, err := os.Stat(filepath.Join(tempDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", name)
+		}
+	}
+	for _, name := range kept {
+		if _, err := os.Stat(filepath.Join(tempDir, name)); err != nil {
+			t.Errorf("Expected %s to be kept: %v", name, err)
+		}
+	}
+
+	// A destination that does not exist yet has nothing to clean up
+	if removed, err := removeTempFiles(filepath.Join(tempDir, "missing")); removed != 0 || err != nil {
+		t.Errorf("Expected nothing to be removed, got %d, %v", removed, err)
+	}
+}
+
+// ageTempFiles makes the named files under dir look like leftovers of a run that crashed long ago
+func ageTempFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	old := time.Now().Add(-2 * staleTempFileAge)
+	for _, name := range names {
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatalf("Failed to age %s: %v", name, err)
+		}
+	}
+}
+
+// TestProcessFilesRemovesTempFiles tests that a run cleans up after an interrupted one
+func TestProcessFilesRemovesTempFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-files-temp")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	leftover := filepath.Join(destDir, "2020", "01", "02", tempFilePrefix+"123")
+	createLibrary(t, tempDir, map[string]string{
+		filepath.Join("src", "photo.jpg"):                                  "Photo",
+		filepath.Join("dest", "2020", "01", "02", filepath.Base(leftover)): "Truncated",
+	})
+	ageTempFiles(t, destDir, filepath.Join("2020", "01", "02", filepath.Base(leftover)))
+
+	logPath := filepath.Join(tempDir, "test.log")
+	if err := ProcessFiles(context.Background(), srcDir, destDir, logPath, NewState(1), NewMockMessenger(), Options{}); err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("Expected the leftover temporary file to be removed")
+	}
+	log, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if !strings.Contains(string(log), "Removed 1 temporary file(s)") {
+		t.Errorf("Expected the cleanup to be logged, got %q", log)
+	}
+}
+
+// TestConcurrentNamingConflicts tests that workers placing different files under the same name
+// never overwrite each other, even without a shared registry as between separate runs
+func TestConcurrentNamingConflicts(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-concurrent-conflicts")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	const workers = 50
+	destPath := filepath.Join(tempDir, "dest", "IMG_0001.JPG")
+	if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
+		t.Fatalf("Failed to create destination directory: %v", err)
+	}
+	files := make(map[string]string, 2*workers)
+	for i := 0; i < workers; i++ {
+		files[fmt.Sprintf("copy%d.jpg", i)] = fmt.Sprintf("Copied by worker %d", i)
+		files[fmt.Sprintf("move%d.jpg", i)] = fmt.Sprintf("Moved by worker %d", i)
+	}
+	createLibrary(t, filepath.Join(tempDir, "src"), files)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*workers)
+	for name := range files {
+		wg.Add(1)
+		go func(src string) {
+			defer wg.Done()
+			_, err := resolveNamingConflict(destPath, func(dest string) error {
+				if strings.HasPrefix(filepath.Base(src), "move") {
+					return moveFile(src, dest, "", HashMD5, DefaultPreserve)
+				}
+				return copyFile(src, dest, Preserve{})
+			})
+			errs <- err
+		}(filepath.Join(tempDir, "src", name))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Failed to place file: %v", err)
+		}
+	}
+
+	placed := make(map[string]bool)
+	entries, _ := os.ReadDir(filepath.Dir(destPath))
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(filepath.Dir(destPath), entry.Name()))
+		if err != nil {
+			t.Fatalf("Failed to read placed file: %v", err)
+		}
+		placed[string(content)] = true
+	}
+	if len(entries) != len(files) {
+		t.Errorf("Expected %d placed files, found %d", len(files), len(entries))
+	}
+	for _, content := range files {
+		if !placed[content] {
+			t.Errorf("Expected %q to be placed, but it was lost", content)
+		}
+	}
+}
+
+// TestProcessFilesSameNames tests that many same-named files with different content processed
+// in parallel all end up in the destination
+func TestProcessFilesSameNames(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-same-names")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Files without a date keep their name in nodata, and so do all their duplicates
+	const cameras = 40
+	srcDir := filepath.Join(tempDir, "src")
+	files := make(map[string]string, 2*cameras)
+	for i := 0; i < cameras; i++ {
+		files[filepath.Join(fmt.Sprintf("camera%d", i), "IMG_0001.JPG")] = fmt.Sprintf("Taken by camera %d", i)
+		files[filepath.Join(fmt.Sprintf("backup%d", i), "IMG_0001.JPG")] = fmt.Sprintf("Taken by camera %d", i)
+	}
+	createLibrary(t, srcDir, files)
+
+	destDir := filepath.Join(tempDir, "dest")
+	state := NewState(2 * cameras)
+	if err := ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), Options{}); err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+	if state.GetErrorCount() != 0 {
+		t.Errorf("Expected no errors, got %d", state.GetErrorCount())
+	}
+
+	for _, dir := range []string{"nodata", "duplicates"} {
+		placed := make(map[string]bool)
+		entries, _ := os.ReadDir(filepath.Join(destDir, dir))
+		for _, entry := range entries {
+			content, err := os.ReadFile(filepath.Join(destDir, dir, entry.Name()))
+			if err != nil {
+				t.Fatalf("Failed to read placed file: %v", err)
+			}
+			placed[string(content)] = true
+		}
+		if len(entries) != cameras || len(placed) != cameras {
+			t.Errorf("Expected %d different files in %s, found %d files with %d contents", cameras, dir, len(entries), len(placed))
+		}
+	}
+}
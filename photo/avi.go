@@ -0,0 +1,110 @@
+package photo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// maxRIFFDate is the largest date chunk read; longer ones are not dates.
+const maxRIFFDate = 256
+
+// riffDateLayouts are the formats that cameras and editors write into IDIT and ICRD chunks.
+var riffDateLayouts = []string{
+	time.ANSIC, // IDIT of DV camcorders, often in capitals: SAT DEC 31 23:59:59 2005
+	"2006:01:02 15:04:05",
+	"2006/01/02 15:04:05",
+	"2006/01/02/ 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// getAVICreationDate reads the creation date of an AVI file of the given size. The IDIT chunk
+// in the header list, written by camcorders, is preferred over the ICRD creation date of the
+// INFO list, which editors write and often only holds a day.
+func getAVICreationDate(r io.ReaderAt, size int64) (time.Time, error) {
+	var header [12]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return time.Time{}, err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:12]) != "AVI " {
+		return time.Time{}, errors.New("not an AVI file")
+	}
+
+	end := 8 + int64(binary.LittleEndian.Uint32(header[4:8]))
+	if end > size {
+		end = size // A recording that was cut short
+	}
+	dates := make(map[string]string)
+	if err := readRIFFDates(r, 12, end, dates); err != nil && len(dates) == 0 {
+		return time.Time{}, err
+	}
+	for _, id := range []string{"IDIT", "ICRD"} {
+		if date, ok := parseRIFFDate(dates[id]); ok {
+			return date, nil
+		}
+	}
+	return time.Time{}, errNoVideoDate
+}
+
+// readRIFFDates collects the IDIT and ICRD chunks between start and end into dates, descending
+// into the header (hdrl) and INFO lists. Other lists, such as the sample data, are skipped.
+func readRIFFDates(r io.ReaderAt, start, end int64, dates map[string]string) error {
+	for offset := start; offset+8 <= end; {
+		var header [8]byte
+		if _, err := r.ReadAt(header[:], offset); err != nil {
+			return err
+		}
+		id := string(header[:4])
+		payload := offset + 8
+		size := int64(binary.LittleEndian.Uint32(header[4:8]))
+		if size > end-payload {
+			return fmt.Errorf("invalid size of chunk %q at offset %d", id, offset)
+		}
+
+		switch id {
+		case "LIST":
+			var listType [4]byte
+			if size < 4 {
+				break
+			}
+			if _, err := r.ReadAt(listType[:], payload); err != nil {
+				return err
+			}
+			if string(listType[:]) == "hdrl" || string(listType[:]) == "INFO" {
+				if err := readRIFFDates(r, payload+4, payload+size, dates); err != nil {
+					return err
+				}
+			}
+		case "IDIT", "ICRD":
+			if size <= maxRIFFDate {
+				value := make([]byte, size)
+				if _, err := r.ReadAt(value, payload); err != nil {
+					return err
+				}
+				dates[id] = string(value)
+			}
+		}
+		offset = payload + size + size%2 // Chunks are padded to an even size
+	}
+	return nil
+}
+
+// parseRIFFDate parses the value of an IDIT or ICRD chunk, trimmed of its terminator and line
+// break. Month and day names are matched regardless of case.
+func parseRIFFDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range riffDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
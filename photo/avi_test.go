@@ -0,0 +1,81 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// riffChunk returns a RIFF chunk with the given ID around the concatenated payloads, padded
+// to an even size
+func riffChunk(id string, payloads ...[]byte) []byte {
+	payload := bytes.Join(payloads, nil)
+	var b bytes.Buffer
+	b.WriteString(id)
+	binary.Write(&b, binary.LittleEndian, uint32(len(payload)))
+	b.Write(payload)
+	if len(payload)%2 == 1 {
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+// aviFile returns an AVI file with the given chunks in its header list, and info in an INFO
+// list if set
+func aviFile(headerChunks [][]byte, info []byte) []byte {
+	chunks := [][]byte{
+		riffChunk("LIST", append([]byte("hdrl"), bytes.Join(append([][]byte{riffChunk("avih", make([]byte, 56))}, headerChunks...), nil)...)),
+	}
+	if info != nil {
+		chunks = append(chunks, riffChunk("LIST", []byte("INFO"), info))
+	}
+	chunks = append(chunks, riffChunk("LIST", []byte("movi"), riffChunk("00dc", bytes.Repeat([]byte{0x42}, 4095))))
+	return riffChunk("RIFF", []byte("AVI "), bytes.Join(chunks, nil))
+}
+
+// TestGetAVICreationDate tests the IDIT and ICRD dates read from synthetic AVI files
+func TestGetAVICreationDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  []byte
+		expected time.Time
+	}{
+		{"DV camcorder IDIT", aviFile([][]byte{riffChunk("IDIT", []byte("SAT DEC 31 23:59:59 2005\n\x00"))}, nil), time.Date(2005, 12, 31, 23, 59, 59, 0, time.UTC)},
+		{"Single digit day", aviFile([][]byte{riffChunk("IDIT", []byte("Mon Jan  2 08:15:00 2006\n\x00"))}, nil), time.Date(2006, 1, 2, 8, 15, 0, 0, time.UTC)},
+		{"EXIF style IDIT", aviFile([][]byte{riffChunk("IDIT", []byte("2004:05:06 07:08:09\x00"))}, nil), time.Date(2004, 5, 6, 7, 8, 9, 0, time.UTC)},
+		{"ICRD", aviFile(nil, riffChunk("ICRD", []byte("2003-02-01\x00"))), time.Date(2003, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"IDIT over ICRD", aviFile(
+			[][]byte{riffChunk("IDIT", []byte("Fri Jun 10 12:00:00 2005\n\x00"))},
+			riffChunk("ICRD", []byte("2011-01-01\x00")),
+		), time.Date(2005, 6, 10, 12, 0, 0, 0, time.UTC)},
+		{"ICRD when IDIT is invalid", aviFile(
+			[][]byte{riffChunk("IDIT", []byte("Not a date\x00"))},
+			riffChunk("ICRD", []byte("2002-03-04 05:06:07\x00")),
+		), time.Date(2002, 3, 4, 5, 6, 7, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		date, err := getAVICreationDate(bytes.NewReader(test.content), int64(len(test.content)))
+		if err != nil || !date.Equal(test.expected) {
+			t.Errorf("%s: Expected %v, got %v, %v", test.name, test.expected, date, err)
+		}
+	}
+
+	// A recording cut short still has its header
+	content := tests[0].content
+	truncated := content[:len(content)-1000]
+	if date, err := getAVICreationDate(bytes.NewReader(truncated), int64(len(truncated))); err != nil || !date.Equal(tests[0].expected) {
+		t.Errorf("Expected the date of a truncated file, got %v, %v", date, err)
+	}
+
+	content = aviFile(nil, nil)
+	if _, err := getAVICreationDate(bytes.NewReader(content), int64(len(content))); !errors.Is(err, errNoVideoDate) {
+		t.Errorf("Expected errNoVideoDate without date chunks, got %v", err)
+	}
+	content = []byte("This is not a valid video file")
+	if date, err := getAVICreationDate(bytes.NewReader(content), int64(len(content))); err == nil {
+		t.Errorf("Expected an error for an invalid file, got %v", date)
+	}
+}
@@ -0,0 +1,139 @@
+package photo
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
+
+// gifXMPApplication identifies the application extension of a GIF image that holds XMP data.
+const gifXMPApplication = "XMP DataXMP"
+
+// gifXMPTrailer starts the trailer after the XMP packet of a GIF image. The packet is stored as
+// is rather than in sub-blocks, and the trailer, a byte of 1 followed by the bytes 255 down to
+// 0, leads a decoder reading it as sub-blocks to the block terminator. Its bytes 255 and 254
+// cannot occur in the UTF-8 text of the packet.
+var gifXMPTrailer = []byte{0x01, 0xFF, 0xFE}
+
+// getGIFCreationDate extracts the creation date of a GIF image of the given size from its XMP
+// data. GIF images have no EXIF data.
+func getGIFCreationDate(r io.ReaderAt, size int64) time.Time {
+	packet, err := readGIFXMP(bufio.NewReader(io.NewSectionReader(r, 0, size)))
+	if err != nil {
+		return time.Time{}
+	}
+	return getXMPCreationDate(packet)
+}
+
+// readGIFXMP returns the XMP packet of a GIF image. The blocks are read in order, skipping the
+// color tables and the image data, until the XMP application extension or the trailer.
+func readGIFXMP(r *bufio.Reader) ([]byte, error) {
+	var header [13]byte // Signature, version and logical screen descriptor
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if string(header[:6]) != "GIF87a" && string(header[:6]) != "GIF89a" {
+		return nil, errors.New("not a GIF image")
+	}
+	if err := skipGIFColorTable(r, header[10]); err != nil {
+		return nil, err
+	}
+
+	for {
+		introducer, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch introducer {
+		case 0x21: // Extension
+			label, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if label == 0xFF {
+				application, err := r.Peek(1 + len(gifXMPApplication))
+				if err == nil && application[0] == byte(len(gifXMPApplication)) && string(application[1:]) == gifXMPApplication {
+					r.Discard(len(application))
+					return readGIFXMPPacket(r)
+				}
+			}
+			if err := skipGIFSubBlocks(r); err != nil {
+				return nil, err
+			}
+		case 0x2C: // Image descriptor
+			var descriptor [9]byte
+			if _, err := io.ReadFull(r, descriptor[:]); err != nil {
+				return nil, err
+			}
+			if err := skipGIFColorTable(r, descriptor[8]); err != nil {
+				return nil, err
+			}
+			if _, err := r.ReadByte(); err != nil { // LZW minimum code size
+				return nil, err
+			}
+			if err := skipGIFSubBlocks(r); err != nil {
+				return nil, err
+			}
+		case 0x3B: // Trailer
+			return nil, errors.New("no XMP data found")
+		default:
+			return nil, errors.New("invalid GIF block")
+		}
+	}
+}
+
+// readGIFXMPPacket reads the data of the XMP application extension up to its block terminator
+// and returns it without the trailer.
+func readGIFXMPPacket(r *bufio.Reader) ([]byte, error) {
+	var data bytes.Buffer
+	for {
+		length, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if length == 0 {
+			break
+		}
+		if data.Len() > maxMetadataBox {
+			return nil, errors.New("XMP data too large")
+		}
+		data.WriteByte(length)
+		if _, err := io.CopyN(&data, r, int64(length)); err != nil {
+			return nil, err
+		}
+	}
+	packet := data.Bytes()
+	trailer := bytes.LastIndex(packet, gifXMPTrailer)
+	if trailer < 0 {
+		return nil, errors.New("XMP data without a trailer")
+	}
+	return packet[:trailer], nil
+}
+
+// skipGIFColorTable skips the color table that the flags of a screen or image descriptor
+// announce, if any.
+func skipGIFColorTable(r *bufio.Reader, flags byte) error {
+	if flags&0x80 == 0 {
+		return nil
+	}
+	_, err := r.Discard(3 << (flags&0x07 + 1))
+	return err
+}
+
+// skipGIFSubBlocks skips data sub-blocks up to and including their block terminator.
+func skipGIFSubBlocks(r *bufio.Reader) error {
+	for {
+		length, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if length == 0 {
+			return nil
+		}
+		if _, err := r.Discard(int(length)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package photo
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// gifFile returns a GIF image with a global color table and a frame, followed by an XMP
+// application extension holding packet if it is not nil
+func gifFile(packet []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("GIF89a")
+	b.Write([]byte{1, 0, 1, 0, 0x80, 0, 0}) // 1x1 with a global color table of two colors
+	b.Write(make([]byte, 6))
+	b.Write([]byte{0x21, 0xF9, 4, 0, 0, 0, 0, 0}) // Graphic control extension
+	b.Write([]byte{0x2C, 0, 0, 0, 0, 1, 0, 1, 0, 0})
+	b.Write([]byte{2, 2, 0x44, 0x01, 0})
+	if packet != nil {
+		b.Write([]byte{0x21, 0xFF, 11})
+		b.WriteString(gifXMPApplication)
+		b.Write(packet)
+		b.WriteByte(1)
+		for i := 255; i >= 0; i-- {
+			b.WriteByte(byte(i))
+		}
+		b.WriteByte(0)
+	}
+	b.WriteByte(0x3B)
+	return b.Bytes()
+}
+
+// TestGetGIFCreationDate tests the dates read from the XMP data of GIF images
+func TestGetGIFCreationDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  []byte
+		expected string
+	}{
+		{"XMP", gifFile(xmpPacket(`xmp:CreateDate="2020-05-06T07:08:09"/>`)), "2020-05-06 07:08:09"},
+		{"XMP element", gifFile(xmpPacket(`><exif:DateTimeOriginal>2020-05-06T07:08:09</exif:DateTimeOriginal></rdf:Description>`)), "2020-05-06 07:08:09"},
+		{"No XMP", gifFile(nil), ""},
+		{"Not a GIF image", []byte("This is not a valid image file"), ""},
+	}
+
+	for _, test := range tests {
+		date := getGIFCreationDate(bytes.NewReader(test.content), int64(len(test.content)))
+		got := ""
+		if !date.IsZero() {
+			got = date.Format(time.DateTime)
+		}
+		if got != test.expected {
+			t.Errorf("%s: Expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+}
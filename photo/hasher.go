@@ -0,0 +1,74 @@
+package photo
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+
+	"github.com/zeebo/xxh3"
+)
+
+// Hasher names the algorithm used to checksum files. Checksums of different algorithms are
+// never compared, so the algorithm is recorded with every checksum written to the index, the
+// journal and plans. The zero value is MD5, the algorithm of records written before the
+// algorithm could be chosen.
+type Hasher string
+
+const (
+	HashMD5    Hasher = "md5"    // Compatible with libraries organized by earlier versions
+	HashSHA256 Hasher = "sha256" // For archival integrity records
+	HashXXH3   Hasher = "xxh3"   // 128-bit XXH3, much faster but not cryptographic
+)
+
+// ParseHasher returns the Hasher with the given name.
+func ParseHasher(name string) (Hasher, error) {
+	switch hasher := Hasher(name); hasher {
+	case HashMD5, HashSHA256, HashXXH3:
+		return hasher, nil
+	}
+	return "", fmt.Errorf("unknown hash algorithm %q", name)
+}
+
+// New returns a hash.Hash computing checksums of this algorithm.
+func (h Hasher) New() hash.Hash {
+	switch h.resolved() {
+	case HashSHA256:
+		return sha256.New()
+	case HashXXH3:
+		return xxh3Hash128{xxh3.New()}
+	default:
+		return md5.New()
+	}
+}
+
+// String returns the name of the algorithm.
+func (h Hasher) String() string {
+	return string(h.resolved())
+}
+
+// resolved returns the algorithm the zero value stands for, or h itself.
+func (h Hasher) resolved() Hasher {
+	if h == "" {
+		return HashMD5
+	}
+	return h
+}
+
+// matches reports whether checksums of h and other can be compared.
+func (h Hasher) matches(other Hasher) bool {
+	return h.resolved() == other.resolved()
+}
+
+// xxh3Hash128 makes an xxh3.Hasher return its 128-bit sum, which keeps accidental collisions
+// as unlikely as with MD5.
+type xxh3Hash128 struct {
+	*xxh3.Hasher
+}
+
+func (h xxh3Hash128) Size() int { return 16 }
+
+func (h xxh3Hash128) Sum(b []byte) []byte {
+	sum := h.Sum128().Bytes()
+	return append(b, sum[:]...)
+}
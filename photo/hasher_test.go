@@ -0,0 +1,258 @@
+package photo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/xxh3"
+)
+
+// TestHasher tests that every algorithm produces its own checksum and that the zero value is MD5
+func TestHasher(t *testing.T) {
+	content := "hello"
+	sha := sha256.Sum256([]byte(content))
+	xxh := xxh3.HashString128(content).Bytes()
+
+	tests := []struct {
+		hasher   Hasher
+		expected string
+	}{
+		{"", md5Hex(content)},
+		{HashMD5, md5Hex(content)},
+		{HashSHA256, hex.EncodeToString(sha[:])},
+		{HashXXH3, hex.EncodeToString(xxh[:])},
+	}
+	for _, test := range tests {
+		hash := test.hasher.New()
+		hash.Write([]byte(content))
+		if result := hex.EncodeToString(hash.Sum(nil)); result != test.expected {
+			t.Errorf("Expected %s checksum %s, got %s", test.hasher, test.expected, result)
+		}
+		if len(test.expected) != 2*test.hasher.New().Size() {
+			t.Errorf("Expected %s size %d, got %d", test.hasher, len(test.expected)/2, test.hasher.New().Size())
+		}
+	}
+
+	if Hasher("").String() != "md5" || !Hasher("").matches(HashMD5) || HashMD5.matches(HashSHA256) {
+		t.Errorf("Expected the zero value to stand for MD5 only")
+	}
+	for _, name := range []string{"md5", "sha256", "xxh3"} {
+		if hasher, err := ParseHasher(name); err != nil || hasher.String() != name {
+			t.Errorf("ParseHasher(%q) = %q, %v", name, hasher, err)
+		}
+	}
+	if _, err := ParseHasher("crc32"); err == nil {
+		t.Errorf("Expected an error for an unknown algorithm")
+	}
+}
+
+// TestProcessFilesWithHasher tests that the chosen algorithm names files and is recorded for undo
+func TestProcessFilesWithHasher(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-files-hasher")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	files := map[string]string{
+		"a.txt": "Same content",
+		"b.txt": "Same content",
+	}
+	createLibrary(t, srcDir, files)
+
+	journalPath := filepath.Join(tempDir, "journal.jsonl")
+	journal, err := OpenJournal(journalPath, HashSHA256)
+	if err != nil {
+		t.Fatalf("OpenJournal returned an error: %v", err)
+	}
+	index, err := OpenIndex(filepath.Join(destDir, IndexFileName), HashSHA256)
+	if err != nil {
+		t.Fatalf("OpenIndex returned an error: %v", err)
+	}
+	options := Options{Journal: journal, Index: index, Hasher: HashSHA256, Deterministic: true}
+	state := NewState(len(files))
+	if err := ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), options); err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+	journal.Close()
+	index.Close()
+
+	sum := sha256.Sum256([]byte("Same content"))
+	checksum := hex.EncodeToString(sum[:])
+	if state.GetNoDataCount() != 1 || state.GetDuplicateCount() != 1 {
+		t.Errorf("Expected 1 original and 1 duplicate, got %d and %d", state.GetNoDataCount(), state.GetDuplicateCount())
+	}
+
+	entries, err := ReadJournal(journalPath)
+	if err != nil {
+		t.Fatalf("ReadJournal returned an error: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Hasher != HashSHA256 || entry.Checksum != checksum {
+			t.Errorf("Expected a SHA-256 journal entry, got %s %s", entry.Hasher, entry.Checksum)
+		}
+	}
+
+	// An index of another algorithm is never compared against
+	md5Index, err := OpenIndex(filepath.Join(destDir, IndexFileName), HashMD5)
+	if err != nil {
+		t.Fatalf("OpenIndex returned an error: %v", err)
+	}
+	if len(md5Index.Originals()) != 0 {
+		t.Errorf("Expected no MD5 originals, got %d", len(md5Index.Originals()))
+	}
+	records := readIndexRecords(t, filepath.Join(destDir, IndexFileName))
+	if len(records) != 2 {
+		t.Errorf("Expected 2 records in the index file, got %d", len(records))
+	}
+	for _, record := range records {
+		if record.Hasher != HashSHA256 || record.Checksum != checksum {
+			t.Errorf("Expected a SHA-256 index record, got %s %s", record.Hasher, record.Checksum)
+		}
+	}
+	err = ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), NewState(len(files)), NewMockMessenger(), Options{Index: md5Index, Hasher: HashSHA256})
+	if err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Errorf("Expected an error for an index of another algorithm, got %v", err)
+	}
+
+	// Undo verifies the destinations with the recorded algorithm
+	report, err := Undo(journalPath, "")
+	if err != nil {
+		t.Fatalf("Undo returned an error: %v", err)
+	}
+	if report.Removed != 2 || len(report.Skipped) != 0 {
+		t.Errorf("Expected 2 removed copies and no skipped entries, got %d and %v", report.Removed, report.Skipped)
+	}
+}
+
+// TestScanLibraryForeignChecksums tests that name prefixes of another algorithm are not trusted
+func TestScanLibraryForeignChecksums(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-scan-library-foreign")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A library organized with MD5 names and index records
+	content := "Organized with MD5"
+	organized := filepath.Join(tempDir, "2020", "01", "02", "photo_"+md5Hex(content)[:8]+".jpg")
+	createLibrary(t, tempDir, map[string]string{
+		filepath.Join("2020", "01", "02", filepath.Base(organized)): content,
+	})
+	index, err := OpenIndex(filepath.Join(tempDir, IndexFileName), HashMD5)
+	if err != nil {
+		t.Fatalf("OpenIndex returned an error: %v", err)
+	}
+	if err := index.add(IndexRecord{Checksum: md5Hex(content), Path: organized, Source: "photo.jpg"}); err != nil {
+		t.Fatalf("Failed to add index record: %v", err)
+	}
+	index.Close()
+
+	// Scanned with SHA-256, the file is hashed instead of keyed by its MD5 prefix
+	index, err = OpenIndex(filepath.Join(tempDir, IndexFileName), HashSHA256)
+	if err != nil {
+		t.Fatalf("OpenIndex returned an error: %v", err)
+	}
+	library, err := ScanLibrary(context.Background(), tempDir, index, HashSHA256, NewState(0), NewMockMessenger())
+	if err != nil {
+		t.Fatalf("ScanLibrary returned an error: %v", err)
+	}
+	if len(library.byPrefix) != 0 {
+		t.Errorf("Expected no trusted name prefixes, got %v", library.byPrefix)
+	}
+	sum := sha256.Sum256([]byte(content))
+	if path, found := library.lookup(hex.EncodeToString(sum[:]), int64(len(content))); !found || path != organized {
+		t.Errorf("Expected the file to be found by its SHA-256 checksum, got %q, %v", path, found)
+	}
+	if _, found := library.lookup(md5Hex(content), int64(len(content))); found {
+		t.Errorf("Expected the MD5 checksum not to match")
+	}
+}
+
+// TestProcessFilesIntoMD5Library tests that a library named with MD5 prefixes is matched by other algorithms
+func TestProcessFilesIntoMD5Library(t *testing.T) {
+	for _, hasher := range []Hasher{HashSHA256, HashXXH3} {
+		tempDir, err := os.MkdirTemp("", "test-md5-library")
+		if err != nil {
+			t.Fatalf("Failed to create temp directory: %v", err)
+		}
+		defer os.RemoveAll(tempDir)
+
+		// Organized by an earlier run with MD5 and no index
+		content := "Imported before"
+		srcDir := filepath.Join(tempDir, "src")
+		destDir := filepath.Join(tempDir, "dest")
+		organized := filepath.Join("2020", "01", "02", "a_"+md5Hex(content)[:8]+".jpg")
+		createLibrary(t, destDir, map[string]string{organized: content})
+		createLibrary(t, srcDir, map[string]string{"a.jpg": content})
+
+		state := NewState(1)
+		library, err := ScanLibrary(context.Background(), destDir, nil, hasher, state, NewMockMessenger())
+		if err != nil {
+			t.Fatalf("%s: ScanLibrary returned an error: %v", hasher, err)
+		}
+		options := Options{Library: library, Hasher: hasher}
+		if err := ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), options); err != nil {
+			t.Fatalf("%s: ProcessFiles returned an error: %v", hasher, err)
+		}
+
+		if state.GetDuplicateCount() != 1 {
+			t.Errorf("%s: Expected the source to be a duplicate of the library file, got %d duplicates", hasher, state.GetDuplicateCount())
+		}
+		if files, _ := os.ReadDir(filepath.Join(destDir, "nodata")); len(files) != 0 {
+			t.Errorf("%s: Expected no second copy of the content, found %d", hasher, len(files))
+		}
+	}
+}
+
+// TestApplyPlanWithHasher tests that a plan records its algorithm and is verified with it
+func TestApplyPlanWithHasher(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-apply-plan-hasher")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	createLibrary(t, srcDir, map[string]string{"clip.mov": "Planned with XXH3"})
+
+	plan := NewPlan(srcDir, destDir, false)
+	err = ProcessFiles(context.Background(), srcDir, destDir, "", NewState(1), NewMockMessenger(), Options{Plan: plan, Hasher: HashXXH3})
+	if err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+	planPath := filepath.Join(tempDir, "plan.json")
+	if err := plan.WriteFile(planPath); err != nil {
+		t.Fatalf("Failed to write plan: %v", err)
+	}
+	loaded, err := LoadPlan(planPath)
+	if err != nil {
+		t.Fatalf("Failed to load plan: %v", err)
+	}
+	if loaded.Hasher != HashXXH3 {
+		t.Errorf("Expected the plan to record xxh3, got %q", loaded.Hasher)
+	}
+
+	index, err := OpenIndex(filepath.Join(destDir, IndexFileName), HashMD5)
+	if err != nil {
+		t.Fatalf("OpenIndex returned an error: %v", err)
+	}
+	if err := ApplyPlan(context.Background(), loaded, filepath.Join(tempDir, "test.log"), NewState(1), NewMockMessenger(), Options{Index: index}); err == nil {
+		t.Errorf("Expected an error for an index of another algorithm")
+	}
+	state := NewState(1)
+	if err := ApplyPlan(context.Background(), loaded, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), Options{}); err != nil {
+		t.Fatalf("ApplyPlan returned an error: %v", err)
+	}
+	if state.GetNoDataCount() != 1 {
+		t.Errorf("Expected 1 applied file, got %d", state.GetNoDataCount())
+	}
+}
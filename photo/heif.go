@@ -0,0 +1,215 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// isHEIFFile reports whether extension belongs to an image in the HEIF container, as HEIC
+// photos of iPhones and AVIF images are.
+func isHEIFFile(extension string) bool {
+	switch strings.ToLower(extension) {
+	case ".heic", ".heif", ".hif", ".avif":
+		return true
+	}
+	return false
+}
+
+// getHEIFCreationDate extracts the EXIF creation date of a HEIF image of the given size. Its
+// EXIF data is an item of the meta box, so only the boxes that lead to it are read.
+func getHEIFCreationDate(r io.ReaderAt, size int64) time.Time {
+	tiff, err := readHEIFExif(r, size)
+	if err != nil {
+		return time.Time{}
+	}
+	return getPhotoCreationDate(bytes.NewReader(tiff), time.Time{})
+}
+
+// heifExtent is a part of the data of a HEIF item.
+type heifExtent struct {
+	offset int64
+	length int64 // Zero for the rest of the file or idat box
+}
+
+// readHEIFExif returns the TIFF structure of the Exif item of a HEIF image. The iinf box of the
+// meta box names the type of each item, and the iloc box lists where its data is: in the file,
+// usually in the mdat box, or in the idat box of the meta box.
+func readHEIFExif(r io.ReaderAt, size int64) ([]byte, error) {
+	top, err := readBoxes(r, 0, size)
+	meta, found := findBox(top, "meta")
+	if !found {
+		if err == nil {
+			err = errors.New("no meta box found")
+		}
+		return nil, err
+	}
+	// Unlike the QuickTime one, the HEIF meta box has a version and flags before its children
+	boxes, err := readBoxes(r, meta.offset+4, meta.offset+meta.size)
+	if err != nil {
+		return nil, err
+	}
+	iinf, hasInfo := findBox(boxes, "iinf")
+	iloc, hasLocations := findBox(boxes, "iloc")
+	if !hasInfo || !hasLocations {
+		return nil, errors.New("no item information or location box found")
+	}
+
+	itemID, err := heifExifItem(r, iinf)
+	if err != nil {
+		return nil, err
+	}
+	method, extents, err := heifItemLocation(r, iloc, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Construction method 0 locates the data in the file and 1 in the idat box
+	base, limit := int64(0), size
+	switch method {
+	case 0:
+	case 1:
+		idat, found := findBox(boxes, "idat")
+		if !found {
+			return nil, errors.New("no item data box found")
+		}
+		base, limit = idat.offset, idat.offset+idat.size
+	default:
+		return nil, fmt.Errorf("unsupported construction method %d", method)
+	}
+	var data []byte
+	for _, extent := range extents {
+		start := base + extent.offset
+		length := extent.length
+		if length == 0 {
+			length = limit - start
+		}
+		if start < base || length < 0 || start+length > limit || int64(len(data))+length > maxMetadataBox {
+			return nil, errors.New("invalid location of the Exif item")
+		}
+		part := make([]byte, length)
+		if _, err := r.ReadAt(part, start); err != nil {
+			return nil, err
+		}
+		data = append(data, part...)
+	}
+
+	// The item starts with the offset of the TIFF header, which usually skips "Exif\0\0"
+	if len(data) < 4 {
+		return nil, errors.New("the Exif item is too short")
+	}
+	skip := int64(binary.BigEndian.Uint32(data[:4]))
+	if 4+skip > int64(len(data)) {
+		return nil, errors.New("invalid TIFF header offset in the Exif item")
+	}
+	return data[4+skip:], nil
+}
+
+// heifExifItem returns the ID of the Exif item listed in an iinf box.
+func heifExifItem(r io.ReaderAt, iinf box) (uint32, error) {
+	var header [6]byte
+	if iinf.size < 6 {
+		return 0, errors.New("item information box too short")
+	}
+	if _, err := r.ReadAt(header[:], iinf.offset); err != nil {
+		return 0, err
+	}
+	// Version 0 counts the entries in 16 bits, later versions in 32
+	start := iinf.offset + 6
+	if header[0] != 0 {
+		start += 2
+	}
+	entries, err := readBoxes(r, start, iinf.offset+iinf.size)
+	if err != nil && len(entries) == 0 {
+		return 0, err
+	}
+
+	for _, infe := range entries {
+		if infe.boxType != "infe" || infe.size < 12 {
+			continue
+		}
+		var entry [14]byte
+		n, _ := r.ReadAt(entry[:min(int64(len(entry)), infe.size)], infe.offset)
+		// Versions 2 and 3 have a type; they store the item ID in 16 and 32 bits
+		switch {
+		case entry[0] == 2 && n >= 12 && string(entry[8:12]) == "Exif":
+			return uint32(binary.BigEndian.Uint16(entry[4:6])), nil
+		case entry[0] == 3 && n >= 14 && string(entry[10:14]) == "Exif":
+			return binary.BigEndian.Uint32(entry[4:8]), nil
+		}
+	}
+	return 0, errors.New("no Exif item found")
+}
+
+// heifItemLocation returns the construction method and the extents of an item from an iloc box.
+func heifItemLocation(r io.ReaderAt, iloc box, itemID uint32) (uint16, []heifExtent, error) {
+	data, err := iloc.payload(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) < 6 {
+		return 0, nil, errors.New("item location box too short")
+	}
+	version := data[0]
+	c := boxCursor{data: data[4:]}
+	sizes := c.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0x0F)
+	sizes = c.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0x0F)
+	}
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+
+	count := c.uint(idSize)
+	for i := uint64(0); i < count && !c.failed; i++ {
+		id := c.uint(idSize)
+		var method uint16
+		if version == 1 || version == 2 {
+			method = uint16(c.uint(2) & 0x0F)
+		}
+		c.uint(2) // Data reference index; only data in this file is supported
+		base := c.uint(baseOffsetSize)
+		extents := make([]heifExtent, c.uint(2))
+		for j := range extents {
+			c.uint(indexSize)
+			extents[j] = heifExtent{
+				offset: int64(base + c.uint(offsetSize)),
+				length: int64(c.uint(lengthSize)),
+			}
+		}
+		if id == uint64(itemID) && !c.failed {
+			return method, extents, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("no location of item %d found", itemID)
+}
+
+// boxCursor reads big-endian integers of varying sizes from the payload of a box. Reading past
+// the end sets failed and returns zero.
+type boxCursor struct {
+	data   []byte
+	failed bool
+}
+
+// uint reads an integer of size bytes; a size of zero reads nothing and returns zero.
+func (c *boxCursor) uint(size int) uint64 {
+	if size > 8 || size > len(c.data) {
+		c.failed = true
+		c.data = nil
+		return 0
+	}
+	var value uint64
+	for _, b := range c.data[:size] {
+		value = value<<8 | uint64(b)
+	}
+	c.data = c.data[size:]
+	return value
+}
@@ -0,0 +1,166 @@
+package photo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fullBox returns a box with a version and zero flags before the concatenated payloads
+func fullBox(boxType string, version byte, payloads ...[]byte) []byte {
+	return isoBox(boxType, []byte{version, 0, 0, 0}, bytes.Join(payloads, nil))
+}
+
+// infeBox returns an item information entry of version 2 or 3, which store the item ID in 16
+// and 32 bits
+func infeBox(version byte, id uint32, itemType string) []byte {
+	var b bytes.Buffer
+	if version == 3 {
+		binary.Write(&b, binary.BigEndian, id)
+	} else {
+		binary.Write(&b, binary.BigEndian, uint16(id))
+	}
+	binary.Write(&b, binary.BigEndian, uint16(0)) // Protection index
+	b.WriteString(itemType)
+	b.WriteByte(0) // Empty name
+	return fullBox("infe", version, b.Bytes())
+}
+
+// heifFile returns a HEIC image whose Exif item records dateTime. The item is stored in the
+// mdat box, or in the idat box of the meta box if inMeta is set, which requires an iloc box of
+// version 1 or 2. Version 2 of iloc and version 3 of infe give the item a 32-bit ID.
+func heifFile(dateTime string, ilocVersion, infeVersion byte, inMeta bool) []byte {
+	exifItem := append([]byte{0, 0, 0, 6}, append([]byte("Exif\x00\x00"), exifTIFF(dateTime)...)...)
+	image := bytes.Repeat([]byte{0x42}, 2048)
+	exifID := uint32(2)
+	if infeVersion == 3 {
+		exifID = 70000
+	}
+
+	ftyp := isoBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	build := func(mdatStart int) []byte {
+		idSize := 2
+		if ilocVersion == 2 {
+			idSize = 4
+		}
+		writeID := func(b *bytes.Buffer, id uint32) {
+			if idSize == 4 {
+				binary.Write(b, binary.BigEndian, id)
+			} else {
+				binary.Write(b, binary.BigEndian, uint16(id))
+			}
+		}
+		writeItem := func(b *bytes.Buffer, id uint32, method uint16, offset, length int) {
+			writeID(b, id)
+			if ilocVersion >= 1 {
+				binary.Write(b, binary.BigEndian, method)
+			}
+			binary.Write(b, binary.BigEndian, uint16(0)) // Data reference index
+			binary.Write(b, binary.BigEndian, uint16(1)) // One extent
+			binary.Write(b, binary.BigEndian, uint32(offset))
+			binary.Write(b, binary.BigEndian, uint32(length))
+		}
+
+		var locations bytes.Buffer
+		locations.Write([]byte{0x44, 0x00}) // 32-bit offsets and lengths, no base offset or index
+		writeID(&locations, 2)              // Number of items
+		writeItem(&locations, 1, 0, mdatStart, len(image))
+		if inMeta {
+			writeItem(&locations, exifID, 1, 0, len(exifItem))
+		} else {
+			writeItem(&locations, exifID, 0, mdatStart+len(image), len(exifItem))
+		}
+
+		boxes := [][]byte{
+			fullBox("hdlr", 0, make([]byte, 4), []byte("pict"), make([]byte, 13)),
+			fullBox("pitm", 0, []byte{0, 1}),
+			fullBox("iinf", 0, []byte{0, 2}, infeBox(infeVersion, 1, "hvc1"), infeBox(infeVersion, exifID, "Exif")),
+			fullBox("iloc", ilocVersion, locations.Bytes()),
+		}
+		mdat := image
+		if inMeta {
+			boxes = append(boxes, isoBox("idat", exifItem))
+		} else {
+			mdat = append(append([]byte{}, image...), exifItem...)
+		}
+		return bytes.Join([][]byte{ftyp, fullBox("meta", 0, boxes...), isoBox("mdat", mdat)}, nil)
+	}
+
+	// The locations have a fixed size, so the first build tells where the sample data starts
+	mdatSize := len(image)
+	if !inMeta {
+		mdatSize += len(exifItem)
+	}
+	return build(len(build(0)) - mdatSize)
+}
+
+// TestGetHEIFCreationDate tests the EXIF dates read from the Exif item of synthetic HEIC images
+func TestGetHEIFCreationDate(t *testing.T) {
+	expected := time.Date(2021, 9, 10, 11, 12, 13, 0, time.UTC)
+	tests := []struct {
+		name        string
+		ilocVersion byte
+		infeVersion byte
+		inMeta      bool
+	}{
+		{"Exif item in mdat", 0, 2, false},
+		{"Exif item in idat", 1, 2, true},
+		{"32-bit item IDs", 2, 3, false},
+	}
+
+	for _, test := range tests {
+		content := heifFile("2021:09:10 11:12:13", test.ilocVersion, test.infeVersion, test.inMeta)
+		date := getHEIFCreationDate(bytes.NewReader(content), int64(len(content)))
+		if date.Format(time.DateTime) != expected.Format(time.DateTime) {
+			t.Errorf("%s: Expected %v, got %v", test.name, expected, date)
+		}
+	}
+
+	// Without an Exif item, or without any boxes, there is no date
+	noExif := isoFile(headerBox("mvhd", 0, expected))
+	for _, content := range [][]byte{noExif, []byte("This is not a valid image file")} {
+		if date := getHEIFCreationDate(bytes.NewReader(content), int64(len(content))); !date.IsZero() {
+			t.Errorf("Expected no date for %d bytes without an Exif item, got %v", len(content), date)
+		}
+	}
+}
+
+// TestProcessFilesHEIF tests that HEIC and AVIF images are organized by their EXIF date and copied in full
+func TestProcessFilesHEIF(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-heif")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"IMG_0001.HEIC": string(heifFile("2022:01:02 03:04:05", 0, 2, false)),
+		"image.avif":    string(heifFile("2022:01:02 06:07:08", 1, 2, true)),
+	}
+	srcDir := filepath.Join(tempDir, "src")
+	createLibrary(t, srcDir, files)
+
+	destDir := filepath.Join(tempDir, "dest")
+	if err := ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), NewState(2), NewMockMessenger(), Options{}); err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(destDir, "2022", "01", "02"))
+	if len(entries) != len(files) {
+		t.Fatalf("Expected %d organized images, found %d", len(files), len(entries))
+	}
+	for _, entry := range entries {
+		copied, _ := os.ReadFile(filepath.Join(destDir, "2022", "01", "02", entry.Name()))
+		found := false
+		for name, content := range files {
+			found = found || (string(copied) == content && filepath.Ext(entry.Name()) == filepath.Ext(name))
+		}
+		if !found {
+			t.Errorf("Expected %s to be a complete copy of its source", entry.Name())
+		}
+	}
+}
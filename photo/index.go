@@ -0,0 +1,296 @@
+package photo
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// IndexFileName is the name of the persistent checksum index kept in the destination directory.
+// The leading dot keeps it out of file counts and source walks.
+const IndexFileName = ".dedupe-index.jsonl"
+
+// IndexRecord describes a source file that has already been organized.
+type IndexRecord struct {
+	Checksum  string    `json:"checksum"`
+	Path      string    `json:"path"` // Where the file was placed in the destination
+	Source    string    `json:"source"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+	Duplicate bool      `json:"duplicate,omitempty"`
+	Collision bool      `json:"collision,omitempty"` // Organized, but another file is the original for Checksum
+	Hasher    Hasher    `json:"hasher,omitempty"`    // Algorithm of Checksum, MD5 if empty
+}
+
+// Index is a persistent, append-only record of organized files. It is loaded at startup so
+// that an interrupted run can be repeated without re-hashing or re-copying finished files.
+// Only checksums of the index's algorithm are used for deduplication; files recorded with
+// another algorithm are still recognized as organized. It is safe for concurrent use.
+type Index struct {
+	mu        sync.Mutex
+	path      string
+	hasher    Hasher
+	file      *os.File               // Opened on the first write, so loading never creates the file
+	originals map[string]string      // checksum -> organized path
+	sources   map[string]IndexRecord // source path -> record
+	paths     map[string]string      // destination path -> checksum
+	foreign   map[string]struct{}    // Destination paths recorded with another algorithm
+	sizes     map[int64]struct{}     // Sizes of the organized files
+}
+
+// OpenIndex loads the index at path if it exists. New records are written with checksums of
+// hasher. Malformed lines, such as a line cut short by an interrupted run, are ignored.
+func OpenIndex(path string, hasher Hasher) (*Index, error) {
+	index := &Index{
+		path:      path,
+		hasher:    hasher.resolved(),
+		originals: make(map[string]string),
+		sources:   make(map[string]IndexRecord),
+		paths:     make(map[string]string),
+		foreign:   make(map[string]struct{}),
+		sizes:     make(map[int64]struct{}),
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record IndexRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		index.remember(record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	return index, nil
+}
+
+// remember adds a record to the in-memory maps. A later original for the same checksum replaces
+// the earlier one, as happens when a keeper policy prefers another file. Records of another
+// algorithm only mark their source as organized. The caller must hold i.mu or own i exclusively.
+func (i *Index) remember(record IndexRecord) {
+	if previous, exists := i.sources[record.Source]; exists && previous.Path != record.Path {
+		delete(i.paths, previous.Path) // The file was moved, e.g. into the duplicates directory
+		delete(i.foreign, previous.Path)
+	}
+	i.sources[record.Source] = record
+	if !record.Hasher.matches(i.hasher) {
+		i.foreign[record.Path] = struct{}{}
+		return
+	}
+	delete(i.foreign, record.Path)
+	i.paths[record.Path] = record.Checksum
+	if !record.Duplicate && !record.Collision {
+		i.originals[record.Checksum] = record.Path
+		i.sizes[record.Size] = struct{}{}
+	}
+}
+
+// Hasher returns the checksum algorithm of the index.
+func (i *Index) Hasher() Hasher {
+	if i == nil {
+		return ""
+	}
+	return i.hasher
+}
+
+// Originals returns a copy of the checksum -> organized path map. Originals whose file is no
+// longer in place, because it was deleted or a run was undone, are dropped from the index.
+func (i *Index) Originals() map[string]string {
+	if i == nil {
+		return nil
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	originals := make(map[string]string, len(i.originals))
+	for checksum, path := range i.originals {
+		if _, err := os.Stat(path); err != nil {
+			delete(i.originals, checksum)
+			delete(i.paths, path)
+			continue
+		}
+		originals[checksum] = path
+	}
+	return originals
+}
+
+// checksumOf returns the checksum recorded for a file in the destination, if any.
+func (i *Index) checksumOf(path string) (string, bool) {
+	if i == nil {
+		return "", false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	checksum, exists := i.paths[path]
+	return checksum, exists
+}
+
+// foreignChecksum reports whether a file in the destination was recorded with a checksum of
+// another algorithm.
+func (i *Index) foreignChecksum(path string) bool {
+	if i == nil {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	_, exists := i.foreign[path]
+	return exists
+}
+
+// hasSize reports whether a file organized by a previous run has the given size.
+func (i *Index) hasSize(size int64) bool {
+	if i == nil {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	_, exists := i.sizes[size]
+	return exists
+}
+
+// done reports whether a source file with the given size and modification time has already
+// been organized by a previous run and its destination is still in place.
+func (i *Index) done(source string, info os.FileInfo) bool {
+	if i == nil {
+		return false
+	}
+	i.mu.Lock()
+	record, exists := i.sources[source]
+	i.mu.Unlock()
+
+	if !exists || record.Size != info.Size() || !record.ModTime.Equal(info.ModTime()) {
+		return false
+	}
+	_, err := os.Stat(record.Path)
+	return err == nil
+}
+
+// add records a finished file and appends it to the index file, so that it survives a crash.
+// The checksum must have been calculated with the index's algorithm. A nil index records nothing.
+func (i *Index) add(record IndexRecord) error {
+	if i == nil {
+		return nil
+	}
+	record.Hasher = i.hasher
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if err := i.writeLocked(record); err != nil {
+		return err
+	}
+	i.remember(record)
+	return nil
+}
+
+// compact rewrites the index file with the latest record of every source whose destination is
+// still in place, ordered by source path, so that the file does not depend on the order in
+// which parallel workers added records. The records are written to a temporary file that then
+// replaces the index, so a crash leaves either the old or the new file.
+func (i *Index) compact() error {
+	if i == nil {
+		return nil
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	records := make([]IndexRecord, 0, len(i.sources))
+	for _, record := range i.sources {
+		if _, err := os.Lstat(record.Path); err == nil {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(a, b int) bool {
+		return records[a].Source < records[b].Source
+	})
+	if _, err := os.Stat(i.path); len(records) == 0 && os.IsNotExist(err) {
+		return nil // Nothing was ever recorded
+	}
+
+	if err := i.closeLocked(); err != nil {
+		return err
+	}
+	dir := filepath.Dir(i.path)
+	temp, err := createTempFile(dir)
+	if err != nil {
+		return fmt.Errorf("failed to compact index: %w", err)
+	}
+	writer := bufio.NewWriter(temp)
+	encoder := json.NewEncoder(writer)
+	for _, record := range records {
+		if err = encoder.Encode(record); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if finishErr := finishTempFile(temp, 0644); err == nil {
+		err = finishErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), i.path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to compact index: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// writeLocked appends a record to the index file, opening it on the first write. The caller
+// must hold i.mu.
+func (i *Index) writeLocked(record IndexRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode index record: %w", err)
+	}
+	if i.file == nil {
+		file, err := os.OpenFile(i.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open index: %w", err)
+		}
+		i.file = file
+	}
+	if _, err := i.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write index record for %s: %w", record.Source, err)
+	}
+	return nil
+}
+
+// Close flushes the index to disk and closes it.
+func (i *Index) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.closeLocked()
+}
+
+// closeLocked implements Close. The index file is opened again by the next write. The caller
+// must hold i.mu.
+func (i *Index) closeLocked() error {
+	if i.file == nil {
+		return nil
+	}
+	file := i.file
+	i.file = nil
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync index: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,283 @@
+package photo
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readIndexRecords returns the well-formed records of the index file at path, in file order
+func readIndexRecords(t *testing.T, path string) []IndexRecord {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open index: %v", err)
+	}
+	defer file.Close()
+
+	var records []IndexRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record IndexRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err == nil {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// TestIndexPersistence tests that records survive reopening the index
+func TestIndexPersistence(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-index-persistence")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	indexPath := filepath.Join(tempDir, IndexFileName)
+	index, err := OpenIndex(indexPath, HashMD5)
+	if err != nil {
+		t.Fatalf("OpenIndex returned an error: %v", err)
+	}
+	if _, err := os.Stat(indexPath); !os.IsNotExist(err) {
+		t.Errorf("Expected loading an index not to create the file")
+	}
+
+	// Create a source file and its organized counterpart
+	srcPath := filepath.Join(tempDir, "source.jpg")
+	destPath := filepath.Join(tempDir, "organized.jpg")
+	for _, path := range []string{srcPath, destPath} {
+		if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		t.Fatalf("Failed to stat source file: %v", err)
+	}
+
+	record := IndexRecord{Checksum: "abc", Path: destPath, Source: srcPath, Size: info.Size(), ModTime: info.ModTime()}
+	if err := index.add(record); err != nil {
+		t.Fatalf("add returned an error: %v", err)
+	}
+	if err := index.add(IndexRecord{Checksum: "abc", Path: "dup.jpg", Source: "other.jpg", Duplicate: true}); err != nil {
+		t.Fatalf("add returned an error: %v", err)
+	}
+	if err := index.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	// Simulate a run that was interrupted in the middle of writing a record
+	file, err := os.OpenFile(indexPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open index file: %v", err)
+	}
+	if _, err := file.WriteString(`{"checksum":"trunc`); err != nil {
+		t.Fatalf("Failed to write to index file: %v", err)
+	}
+	file.Close()
+
+	reopened, err := OpenIndex(indexPath, HashMD5)
+	if err != nil {
+		t.Fatalf("OpenIndex returned an error for a truncated index: %v", err)
+	}
+	if records := readIndexRecords(t, indexPath); len(records) != 2 {
+		t.Errorf("Expected 2 records in the index file, got %d", len(records))
+	}
+	if checksum, found := reopened.checksumOf("dup.jpg"); !found || checksum != "abc" {
+		t.Errorf("Expected the duplicate to be known with checksum abc, got %q, %v", checksum, found)
+	}
+	if originals := reopened.Originals(); len(originals) != 1 || originals["abc"] != destPath {
+		t.Errorf("Expected checksum abc to map to %s, got %v", destPath, originals)
+	}
+	if !reopened.done(srcPath, info) {
+		t.Errorf("Expected %s to be recognized as already organized", srcPath)
+	}
+
+	// Once the organized file disappears the source must be processed again
+	if err := os.Remove(destPath); err != nil {
+		t.Fatalf("Failed to remove organized file: %v", err)
+	}
+	if reopened.done(srcPath, info) {
+		t.Errorf("Expected %s to need processing after its destination was removed", srcPath)
+	}
+}
+
+// TestProcessFilesResume tests that rerunning with an index does not re-copy finished files
+func TestProcessFilesResume(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-files-resume")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	files := map[string]string{
+		"file1.txt":     "Content of file 1",
+		"file2.txt":     "Content of file 2",
+		"duplicate.txt": "Content of file 1",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", name, err)
+		}
+	}
+
+	run := func() *State {
+		index, err := OpenIndex(filepath.Join(destDir, IndexFileName), HashMD5)
+		if err != nil {
+			t.Fatalf("OpenIndex returned an error: %v", err)
+		}
+		defer index.Close()
+
+		state := NewState(len(files))
+		err = ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), Options{Index: index})
+		if err != nil {
+			t.Fatalf("ProcessFiles returned an error: %v", err)
+		}
+		return state
+	}
+
+	first := run()
+	if first.GetSkippedCount() != 0 {
+		t.Errorf("Expected nothing to be skipped on the first run, got %d", first.GetSkippedCount())
+	}
+
+	second := run()
+	if second.GetSkippedCount() != len(files) {
+		t.Errorf("Expected all %d files to be skipped on the second run, got %d", len(files), second.GetSkippedCount())
+	}
+
+	// No file may have been copied a second time
+	err = filepath.Walk(destDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.Contains(info.Name(), "_1") {
+			t.Errorf("Unexpected re-copied file %s", path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk destination directory: %v", err)
+	}
+}
+
+// TestProcessFilesAfterUndo tests that originals removed by undo are not used to detect duplicates
+func TestProcessFilesAfterUndo(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-files-after-undo")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	createLibrary(t, srcDir, map[string]string{"a.jpg": "Moved and moved back"})
+
+	journalPath := filepath.Join(tempDir, "journal.jsonl")
+	run := func() *State {
+		index, err := OpenIndex(filepath.Join(destDir, IndexFileName), HashMD5)
+		if err != nil {
+			t.Fatalf("OpenIndex returned an error: %v", err)
+		}
+		defer index.Close()
+		journal, err := OpenJournal(journalPath, HashMD5)
+		if err != nil {
+			t.Fatalf("OpenJournal returned an error: %v", err)
+		}
+		defer journal.Close()
+
+		state := NewState(1)
+		options := Options{MoveFiles: true, Index: index, Journal: journal}
+		if err := ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), options); err != nil {
+			t.Fatalf("ProcessFiles returned an error: %v", err)
+		}
+		return state
+	}
+
+	run()
+	report, err := Undo(journalPath, "")
+	if err != nil || report.Restored != 1 {
+		t.Fatalf("Expected the move to be undone, got %+v and %v", report, err)
+	}
+
+	second := run()
+	if second.GetNoDataCount() != 1 || second.GetDuplicateCount() != 0 {
+		t.Errorf("Expected the file to be organized again, got %d no-data files and %d duplicates", second.GetNoDataCount(), second.GetDuplicateCount())
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "duplicates", "a.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Expected no copy in the duplicates directory")
+	}
+}
+
+// TestIndexCompact tests that records are written as they are added and sorted by source
+// path, one per source whose destination is in place, once the index is compacted
+func TestIndexCompact(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-index-compact")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	indexPath := filepath.Join(tempDir, IndexFileName)
+	index, err := OpenIndex(indexPath, HashMD5)
+	if err != nil {
+		t.Fatalf("OpenIndex returned an error: %v", err)
+	}
+	defer index.Close()
+
+	organized := func(source string) string { return filepath.Join(tempDir, "organized-"+source) }
+	for _, source := range []string{"c.jpg", "a.jpg", "b.jpg"} {
+		if err := os.WriteFile(organized(source), []byte(source), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		if err := index.add(IndexRecord{Checksum: source, Path: filepath.Join(tempDir, "first-"+source), Source: source}); err != nil {
+			t.Fatalf("add returned an error: %v", err)
+		}
+		if err := index.add(IndexRecord{Checksum: source, Path: organized(source), Source: source}); err != nil {
+			t.Fatalf("add returned an error: %v", err)
+		}
+	}
+	if err := index.add(IndexRecord{Checksum: "gone", Path: organized("gone.jpg"), Source: "gone.jpg"}); err != nil {
+		t.Fatalf("add returned an error: %v", err)
+	}
+
+	// A run that is killed now must not lose its records
+	if records := readIndexRecords(t, indexPath); len(records) != 7 {
+		t.Fatalf("Expected every record to be written as it is added, got %d", len(records))
+	}
+
+	if err := index.compact(); err != nil {
+		t.Fatalf("compact returned an error: %v", err)
+	}
+	records := readIndexRecords(t, indexPath)
+	if len(records) != 3 {
+		t.Fatalf("Expected one record per source still in place, got %d", len(records))
+	}
+	for i, source := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if records[i].Source != source || records[i].Path != organized(source) {
+			t.Errorf("Expected record %d for %s at %s, got %s at %s", i, source, organized(source), records[i].Source, records[i].Path)
+		}
+	}
+
+	// Records added after compaction are appended to the new file
+	if err := index.add(IndexRecord{Checksum: "d.jpg", Path: organized("a.jpg"), Source: "d.jpg", Duplicate: true}); err != nil {
+		t.Fatalf("add returned an error: %v", err)
+	}
+	if records := readIndexRecords(t, indexPath); len(records) != 4 {
+		t.Errorf("Expected a record to be appended after compaction, got %d records", len(records))
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(tempDir, tempFilePrefix+"*")); len(leftovers) != 0 {
+		t.Errorf("Expected no temporary files to be left, got %v", leftovers)
+	}
+}
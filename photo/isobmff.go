@@ -0,0 +1,232 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maxMetadataBox is the largest metadata box payload read into memory. Sample data lives in
+// other boxes, which are only skipped over.
+const maxMetadataBox = 1 << 20
+
+// isoEpoch is the origin of the creation times in ISO base media (MP4) and QuickTime files.
+var isoEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// appleCreationDateKey names the QuickTime metadata item that iPhones and other cameras use for
+// the local time a video was taken, including its time zone.
+const appleCreationDateKey = "com.apple.quicktime.creationdate"
+
+// errNoVideoDate is returned when a container was parsed but holds no creation date.
+var errNoVideoDate = errors.New("no creation date in video metadata")
+
+// box is a box (QuickTime calls it an atom) of an ISO base media file: a four-character type
+// and a payload.
+type box struct {
+	boxType string
+	offset  int64 // Start of the payload in the file
+	size    int64 // Size of the payload
+}
+
+// readBoxes returns the boxes between start and end of r, without reading their payloads.
+func readBoxes(r io.ReaderAt, start, end int64) ([]box, error) {
+	var boxes []box
+	for offset := start; offset+8 <= end; {
+		var header [16]byte
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return boxes, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0: // The box extends to the end
+			size = end - offset
+		case 1: // A 64-bit size follows the type
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return boxes, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || size > end-offset {
+			return boxes, fmt.Errorf("invalid size of box %q at offset %d", header[4:8], offset)
+		}
+		boxes = append(boxes, box{
+			boxType: string(header[4:8]),
+			offset:  offset + headerSize,
+			size:    size - headerSize,
+		})
+		offset += size
+	}
+	return boxes, nil
+}
+
+// findBox returns the first box of the given type.
+func findBox(boxes []box, boxType string) (box, bool) {
+	for _, b := range boxes {
+		if b.boxType == boxType {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// children returns the boxes inside b.
+func (b box) children(r io.ReaderAt) ([]box, error) {
+	return readBoxes(r, b.offset, b.offset+b.size)
+}
+
+// payload reads the payload of b, which must not exceed maxMetadataBox bytes.
+func (b box) payload(r io.ReaderAt) ([]byte, error) {
+	if b.size > maxMetadataBox {
+		return nil, fmt.Errorf("box %q is too large for metadata", b.boxType)
+	}
+	data := make([]byte, b.size)
+	if _, err := r.ReadAt(data, b.offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// getISOCreationDate reads the creation date of an MP4, MOV or 3GP file of the given size. The
+// Apple creation date is preferred, as it records the local time of the recording; otherwise the
+// creation time of the movie header (mvhd) or, failing that, of the first track header that has
+// one (tkhd) is used. Those are UTC, and zero where the encoder did not set them.
+func getISOCreationDate(r io.ReaderAt, size int64) (time.Time, error) {
+	// A box with an invalid size ends the list, but the movie box may come before it
+	top, err := readBoxes(r, 0, size)
+	moov, found := findBox(top, "moov")
+	if !found {
+		if err == nil {
+			err = errors.New("no movie box (moov) found")
+		}
+		return time.Time{}, err
+	}
+	boxes, err := moov.children(r)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if meta, found := findBox(boxes, "meta"); found {
+		if date, ok := appleCreationDate(r, meta); ok {
+			return date, nil
+		}
+	}
+	if mvhd, found := findBox(boxes, "mvhd"); found {
+		if date, ok := headerCreationTime(r, mvhd); ok {
+			return date, nil
+		}
+	}
+	for _, trak := range boxes {
+		if trak.boxType != "trak" {
+			continue
+		}
+		trakBoxes, err := trak.children(r)
+		if err != nil {
+			continue
+		}
+		if tkhd, found := findBox(trakBoxes, "tkhd"); found {
+			if date, ok := headerCreationTime(r, tkhd); ok {
+				return date, nil
+			}
+		}
+	}
+	return time.Time{}, errNoVideoDate
+}
+
+// headerCreationTime reads the creation time of a movie or track header. Version 1 headers
+// store it in 64 bits, version 0 headers in 32.
+func headerCreationTime(r io.ReaderAt, header box) (time.Time, bool) {
+	var data [12]byte
+	if header.size < int64(len(data)) {
+		return time.Time{}, false
+	}
+	if _, err := r.ReadAt(data[:], header.offset); err != nil {
+		return time.Time{}, false
+	}
+	var seconds uint64
+	if data[0] == 1 {
+		seconds = binary.BigEndian.Uint64(data[4:12])
+	} else {
+		seconds = uint64(binary.BigEndian.Uint32(data[4:8]))
+	}
+	if seconds == 0 || seconds > 1<<40 { // Unset, or not a plausible time
+		return time.Time{}, false
+	}
+	return time.Unix(isoEpoch.Unix()+int64(seconds), 0).UTC(), true
+}
+
+// appleCreationDate reads the com.apple.quicktime.creationdate item from a QuickTime metadata
+// box. Its keys box lists the item names; the ilst box holds the values, each in a box whose
+// type is the 1-based index of its key.
+func appleCreationDate(r io.ReaderAt, meta box) (time.Time, bool) {
+	// Unlike the MP4 meta box, the QuickTime one has no version and flags before its children
+	var start [8]byte
+	if _, err := r.ReadAt(start[:], meta.offset); err != nil {
+		return time.Time{}, false
+	}
+	if string(start[4:8]) != "hdlr" {
+		meta.offset += 4
+		meta.size -= 4
+	}
+	boxes, _ := meta.children(r)
+	keys, hasKeys := findBox(boxes, "keys")
+	ilst, hasList := findBox(boxes, "ilst")
+	if !hasKeys || !hasList {
+		return time.Time{}, false
+	}
+
+	keyData, err := keys.payload(r)
+	if err != nil || len(keyData) < 8 {
+		return time.Time{}, false
+	}
+	index := uint32(0)
+	count := binary.BigEndian.Uint32(keyData[4:8])
+	for i, offset := uint32(1), 8; i <= count && offset+8 <= len(keyData); i++ {
+		keySize := int(binary.BigEndian.Uint32(keyData[offset : offset+4]))
+		if keySize < 8 || offset+keySize > len(keyData) {
+			return time.Time{}, false
+		}
+		if string(keyData[offset+8:offset+keySize]) == appleCreationDateKey {
+			index = i
+			break
+		}
+		offset += keySize
+	}
+	if index == 0 {
+		return time.Time{}, false
+	}
+
+	items, _ := ilst.children(r)
+	for _, item := range items {
+		if binary.BigEndian.Uint32([]byte(item.boxType)) != index {
+			continue
+		}
+		values, _ := item.children(r)
+		data, found := findBox(values, "data")
+		if !found {
+			return time.Time{}, false
+		}
+		value, err := data.payload(r)
+		if err != nil || len(value) < 8 {
+			return time.Time{}, false
+		}
+		// The value follows a type indicator and a locale
+		return parseAppleDate(string(bytes.TrimRight(value[8:], "\x00")))
+	}
+	return time.Time{}, false
+}
+
+// parseAppleDate parses an ISO 8601 date as written in QuickTime metadata, such as
+// 2019-06-15T14:30:22+0200.
+func parseAppleDate(value string) (time.Time, bool) {
+	for _, layout := range []string{"2006-01-02T15:04:05-0700", time.RFC3339, "2006-01-02T15:04:05"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,167 @@
+package photo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// isoBox returns an ISO base media box of the given type around the concatenated payloads
+func isoBox(boxType string, payloads ...[]byte) []byte {
+	payload := bytes.Join(payloads, nil)
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(8+len(payload)))
+	b.WriteString(boxType)
+	b.Write(payload)
+	return b.Bytes()
+}
+
+// headerBox returns a movie (mvhd) or track (tkhd) header of the given version created at
+// created, or with no creation time if created is zero
+func headerBox(boxType string, version byte, created time.Time) []byte {
+	var seconds uint64
+	if !created.IsZero() {
+		seconds = uint64(created.Unix() - isoEpoch.Unix())
+	}
+	var b bytes.Buffer
+	b.Write([]byte{version, 0, 0, 0})
+	if version == 1 {
+		binary.Write(&b, binary.BigEndian, seconds) // Creation time
+		binary.Write(&b, binary.BigEndian, seconds) // Modification time
+	} else {
+		binary.Write(&b, binary.BigEndian, uint32(seconds))
+		binary.Write(&b, binary.BigEndian, uint32(seconds))
+	}
+	b.Write(make([]byte, 80)) // The rest of the header is not read
+	return isoBox(boxType, b.Bytes())
+}
+
+// appleMetaBox returns a QuickTime metadata box that stores value for every key, in order
+func appleMetaBox(keys []string, values []string) []byte {
+	var keyList bytes.Buffer
+	binary.Write(&keyList, binary.BigEndian, uint32(0)) // Version and flags
+	binary.Write(&keyList, binary.BigEndian, uint32(len(keys)))
+	var items [][]byte
+	for i, key := range keys {
+		binary.Write(&keyList, binary.BigEndian, uint32(8+len(key)))
+		keyList.WriteString("mdta")
+		keyList.WriteString(key)
+
+		var index [4]byte
+		binary.BigEndian.PutUint32(index[:], uint32(i+1))
+		data := isoBox("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte(values[i]))
+		items = append(items, isoBox(string(index[:]), data))
+	}
+	handler := isoBox("hdlr", make([]byte, 8), []byte("mdta"), make([]byte, 13))
+	return isoBox("meta", handler, isoBox("keys", keyList.Bytes()), isoBox("ilst", items...))
+}
+
+// isoFile returns an MP4 file with some sample data and a movie box holding boxes
+func isoFile(boxes ...[]byte) []byte {
+	ftyp := isoBox("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+	mdat := isoBox("mdat", bytes.Repeat([]byte{0x42}, 4096))
+	return bytes.Join([][]byte{ftyp, mdat, isoBox("moov", boxes...)}, nil)
+}
+
+// TestGetISOCreationDate tests the creation dates read from synthetic MP4 and QuickTime files
+func TestGetISOCreationDate(t *testing.T) {
+	created := time.Date(2018, 7, 14, 9, 30, 0, 0, time.UTC)
+	tracked := time.Date(2017, 3, 2, 1, 0, 0, 0, time.UTC)
+	local := time.FixedZone("", 2*60*60)
+
+	// A large sample box may use a 64-bit size, and the movie box may come first
+	var large bytes.Buffer
+	binary.Write(&large, binary.BigEndian, uint32(1))
+	large.WriteString("mdat")
+	binary.Write(&large, binary.BigEndian, uint64(16+1024))
+	large.Write(make([]byte, 1024))
+	moovFirst := append(isoBox("moov", headerBox("mvhd", 0, created)), large.Bytes()...)
+
+	tests := []struct {
+		name     string
+		content  []byte
+		expected time.Time
+	}{
+		{"mvhd version 0", isoFile(headerBox("mvhd", 0, created)), created},
+		{"mvhd version 1", isoFile(headerBox("mvhd", 1, created)), created},
+		{"tkhd when mvhd is unset", isoFile(headerBox("mvhd", 0, time.Time{}), isoBox("trak", headerBox("tkhd", 0, tracked))), tracked},
+		{"Apple creation date", isoFile(
+			headerBox("mvhd", 0, created),
+			appleMetaBox(
+				[]string{"com.apple.quicktime.make", appleCreationDateKey},
+				[]string{"Apple", "2019-06-15T23:30:22+0200"},
+			),
+		), time.Date(2019, 6, 15, 23, 30, 22, 0, local)},
+		{"mvhd without an Apple creation date", isoFile(
+			appleMetaBox([]string{"com.apple.quicktime.make"}, []string{"Apple"}),
+			headerBox("mvhd", 0, created),
+		), created},
+		{"64-bit sizes", moovFirst, created},
+	}
+
+	for _, test := range tests {
+		date, err := getISOCreationDate(bytes.NewReader(test.content), int64(len(test.content)))
+		if err != nil {
+			t.Errorf("%s: getISOCreationDate returned an error: %v", test.name, err)
+			continue
+		}
+		if !date.Equal(test.expected) {
+			t.Errorf("%s: Expected %v, got %v", test.name, test.expected, date)
+		}
+		// The local date decides the folder
+		if date.Format("2006-01-02") != test.expected.Format("2006-01-02") {
+			t.Errorf("%s: Expected the date %s, got %s", test.name, test.expected.Format("2006-01-02"), date.Format("2006-01-02"))
+		}
+	}
+
+	// Files without a creation time or without a movie box have no date
+	unset := isoFile(headerBox("mvhd", 0, time.Time{}), isoBox("trak", headerBox("tkhd", 0, time.Time{})))
+	if _, err := getISOCreationDate(bytes.NewReader(unset), int64(len(unset))); !errors.Is(err, errNoVideoDate) {
+		t.Errorf("Expected errNoVideoDate for unset creation times, got %v", err)
+	}
+	for _, content := range [][]byte{isoBox("ftyp", []byte("isom")), []byte("This is not a valid video file")} {
+		if date, err := getISOCreationDate(bytes.NewReader(content), int64(len(content))); err == nil {
+			t.Errorf("Expected an error for %q, got %v", content, date)
+		}
+	}
+}
+
+// TestProcessFilesVideoDate tests that videos are organized by their creation date and copied in full
+func TestProcessFilesVideoDate(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-video-date")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	content := string(isoFile(headerBox("mvhd", 0, time.Date(2016, 12, 24, 18, 0, 0, 0, time.UTC))))
+	srcDir := filepath.Join(tempDir, "src")
+	createLibrary(t, srcDir, map[string]string{
+		"clip.mov":  content,
+		"clip.3gp":  content + "A different size",
+		"empty.mp4": "No boxes in here",
+	})
+
+	destDir := filepath.Join(tempDir, "dest")
+	if err := ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), NewState(3), NewMockMessenger(), Options{}); err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+
+	// The checksum is taken from the start of the file, whatever the parser read
+	for name, expected := range map[string]string{"clip.mov": content, "clip.3gp": content + "A different size"} {
+		ext := filepath.Ext(name)
+		organized := filepath.Join(destDir, "2016", "12", "24", strings.TrimSuffix(name, ext)+"_"+md5Hex(expected)[:8]+ext)
+		if copied, err := os.ReadFile(organized); err != nil || string(copied) != expected {
+			t.Errorf("Expected %s to be organized at %s, got %d bytes, %v", name, organized, len(copied), err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "nodata", "empty.mp4")); err != nil {
+		t.Errorf("Expected the video without metadata in nodata: %v", err)
+	}
+}
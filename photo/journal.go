@@ -0,0 +1,236 @@
+package photo
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Journal operations. Hardlinks and clones are journaled as copies, since like a copy they
+// can be removed while the source exists.
+const (
+	OperationCopy    = "copy"
+	OperationMove    = "move"
+	OperationSymlink = "symlink"
+)
+
+// JournalEntry records a single file operation performed on behalf of a run.
+type JournalEntry struct {
+	RunID       string    `json:"run_id"`
+	Time        time.Time `json:"time"`
+	Operation   string    `json:"operation"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Checksum    string    `json:"checksum"`
+	Hasher      Hasher    `json:"hasher,omitempty"` // Algorithm of Checksum, MD5 if empty
+}
+
+// Journal is an append-only record of every copy and move, one JSON object per line.
+// It is safe for concurrent use and allows a run to be reversed with Undo.
+type Journal struct {
+	mu     sync.Mutex
+	file   *os.File
+	runID  string
+	hasher Hasher
+}
+
+// OpenJournal opens (or creates) the journal at path for appending and assigns a new run ID.
+// Checksums recorded in the journal are calculated with hasher.
+func OpenJournal(path string, hasher Hasher) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to generate run ID: %w", err)
+	}
+
+	return &Journal{
+		file:   file,
+		runID:  time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix),
+		hasher: hasher.resolved(),
+	}, nil
+}
+
+// RunID returns the identifier written with every entry of this run.
+func (j *Journal) RunID() string {
+	return j.runID
+}
+
+// record appends an entry to the journal. A nil journal records nothing.
+func (j *Journal) record(operation, src, dest, checksum string) error {
+	if j == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(JournalEntry{
+		RunID:       j.runID,
+		Time:        time.Now().UTC(),
+		Operation:   operation,
+		Source:      src,
+		Destination: dest,
+		Checksum:    checksum,
+		Hasher:      j.hasher,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode journal entry: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal entry for %s: %w", src, err)
+	}
+	return nil
+}
+
+// Close flushes the journal to disk and closes it.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.file.Sync(); err != nil {
+		j.file.Close()
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	return j.file.Close()
+}
+
+// ReadJournal reads all entries of a journal in the order they were written.
+func ReadJournal(path string) ([]JournalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid journal entry on line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	return entries, nil
+}
+
+// UndoSkip describes a journal entry that could not be reversed.
+type UndoSkip struct {
+	Entry  JournalEntry
+	Reason string
+}
+
+// UndoReport summarizes the result of Undo.
+type UndoReport struct {
+	Restored int        // Moved files put back at their original path
+	Removed  int        // Copies and links removed from the destination
+	Skipped  []UndoSkip // Entries that were left alone
+}
+
+// Undo reverses the operations recorded in a journal, newest first. If runID is not empty,
+// only entries of that run are reversed. Moved files are renamed back to their original
+// path, recreating source directories as needed, and copies and symbolic links are removed. Entries whose
+// destination was modified or removed since the run are skipped and reported.
+func Undo(journalPath, runID string) (UndoReport, error) {
+	var report UndoReport
+
+	entries, err := ReadJournal(journalPath)
+	if err != nil {
+		return report, err
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if runID != "" && entry.RunID != runID {
+			continue
+		}
+		if reason := undoEntry(entry, &report); reason != "" {
+			report.Skipped = append(report.Skipped, UndoSkip{Entry: entry, Reason: reason})
+		}
+	}
+	return report, nil
+}
+
+// undoEntry reverses a single journal entry, returning a reason if it was skipped.
+func undoEntry(entry JournalEntry, report *UndoReport) string {
+	if entry.Operation == OperationSymlink {
+		return undoSymlink(entry, report)
+	}
+
+	file, err := os.Open(entry.Destination)
+	if err != nil {
+		return "destination no longer exists"
+	}
+	checksum, err := calculateChecksum(file, entry.Hasher)
+	file.Close()
+	if err != nil {
+		return fmt.Sprintf("failed to read destination: %s", err)
+	}
+	if checksum != entry.Checksum {
+		return "destination was modified since the run"
+	}
+
+	_, statErr := os.Stat(entry.Source)
+	sourceExists := statErr == nil
+
+	switch entry.Operation {
+	case OperationCopy:
+		if sourceExists {
+			if err := os.Remove(entry.Destination); err != nil {
+				return fmt.Sprintf("failed to remove copy: %s", err)
+			}
+			report.Removed++
+			return ""
+		}
+		// The original is gone, so the copy is the only one left: put it back instead
+	case OperationMove:
+		if sourceExists {
+			return "original path is occupied"
+		}
+	default:
+		return fmt.Sprintf("unknown operation %q", entry.Operation)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(entry.Source), os.ModePerm); err != nil {
+		return fmt.Sprintf("failed to recreate source directory: %s", err)
+	}
+	// A file moved back across file systems keeps what a rename would keep
+	if err := moveFile(entry.Destination, entry.Source, entry.Checksum, entry.Hasher, DefaultPreserve); err != nil {
+		return err.Error()
+	}
+	report.Restored++
+	return ""
+}
+
+// undoSymlink removes a symbolic link placed for a duplicate. The link holds no data of its own,
+// so it is removed even if its source is gone, as long as it is still a link.
+func undoSymlink(entry JournalEntry, report *UndoReport) string {
+	info, err := os.Lstat(entry.Destination)
+	if err != nil {
+		return "destination no longer exists"
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return "destination was modified since the run"
+	}
+	if err := os.Remove(entry.Destination); err != nil {
+		return fmt.Sprintf("failed to remove link: %s", err)
+	}
+	report.Removed++
+	return ""
+}
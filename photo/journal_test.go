@@ -0,0 +1,185 @@
+package photo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestJournalRecord tests that recorded entries can be read back in order
+func TestJournalRecord(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-journal-record")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	journalPath := filepath.Join(tempDir, "journal.jsonl")
+	journal, err := OpenJournal(journalPath, HashMD5)
+	if err != nil {
+		t.Fatalf("OpenJournal returned an error: %v", err)
+	}
+	if err := journal.record(OperationMove, "src/a.jpg", "dest/a.jpg", "abc"); err != nil {
+		t.Fatalf("record returned an error: %v", err)
+	}
+	if err := journal.record(OperationCopy, "src/b.jpg", "dest/b.jpg", "def"); err != nil {
+		t.Fatalf("record returned an error: %v", err)
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	entries, err := ReadJournal(journalPath)
+	if err != nil {
+		t.Fatalf("ReadJournal returned an error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Operation != OperationMove || entries[0].Source != "src/a.jpg" || entries[0].Checksum != "abc" {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].Operation != OperationCopy || entries[1].Destination != "dest/b.jpg" {
+		t.Errorf("Unexpected second entry: %+v", entries[1])
+	}
+	for _, entry := range entries {
+		if entry.RunID != journal.RunID() {
+			t.Errorf("Expected run ID %s, got %s", journal.RunID(), entry.RunID)
+		}
+		if entry.Time.IsZero() {
+			t.Errorf("Expected entry to carry a timestamp")
+		}
+	}
+
+	// A nil journal records nothing and does not fail
+	var none *Journal
+	if err := none.record(OperationCopy, "a", "b", "c"); err != nil {
+		t.Errorf("Expected nil journal to ignore records, got %v", err)
+	}
+}
+
+// TestUndoMove tests that a journaled move run can be reversed
+func TestUndoMove(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-undo-move")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	files := map[string]string{
+		"nested/deep/one.txt": "Content of file 1",
+		"two.txt":             "Content of file 2",
+		"modified.txt":        "Content of file 3",
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", name, err)
+		}
+	}
+
+	journalPath := filepath.Join(tempDir, "journal.jsonl")
+	journal, err := OpenJournal(journalPath, HashMD5)
+	if err != nil {
+		t.Fatalf("OpenJournal returned an error: %v", err)
+	}
+	options := Options{MoveFiles: true, Journal: journal}
+	err = ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), NewState(len(files)), NewMockMessenger(), options)
+	if err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	// The moved sources are gone, including their directories
+	if err := os.RemoveAll(filepath.Join(srcDir, "nested")); err != nil {
+		t.Fatalf("Failed to remove source directory: %v", err)
+	}
+
+	// Modify one of the moved files so it cannot be reversed
+	modified := filepath.Join(destDir, "nodata", "modified.txt")
+	if err := os.WriteFile(modified, []byte("Edited after the run"), 0644); err != nil {
+		t.Fatalf("Failed to modify destination file: %v", err)
+	}
+
+	report, err := Undo(journalPath, journal.RunID())
+	if err != nil {
+		t.Fatalf("Undo returned an error: %v", err)
+	}
+	if report.Restored != 2 {
+		t.Errorf("Expected 2 restored files, got %d", report.Restored)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0].Entry.Destination != modified {
+		t.Errorf("Expected the modified file to be skipped, got %+v", report.Skipped)
+	}
+
+	for _, name := range []string{"nested/deep/one.txt", "two.txt"} {
+		content, err := os.ReadFile(filepath.Join(srcDir, name))
+		if err != nil {
+			t.Errorf("Expected %s to be restored: %v", name, err)
+			continue
+		}
+		if string(content) != files[name] {
+			t.Errorf("Restored content of %s doesn't match. Expected %q, got %q", name, files[name], string(content))
+		}
+	}
+}
+
+// TestUndoCopy tests that undoing a copy removes the copy and leaves the original alone
+func TestUndoCopy(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-undo-copy")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcPath := filepath.Join(tempDir, "source.txt")
+	destPath := filepath.Join(tempDir, "copy.txt")
+	if err := os.WriteFile(srcPath, []byte("Copied content"), 0644); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+	if err := copyFile(srcPath, destPath, Preserve{}); err != nil {
+		t.Fatalf("copyFile returned an error: %v", err)
+	}
+
+	file, err := os.Open(srcPath)
+	if err != nil {
+		t.Fatalf("Failed to open source file: %v", err)
+	}
+	checksum, err := calculateChecksum(file, HashMD5)
+	file.Close()
+	if err != nil {
+		t.Fatalf("Failed to calculate checksum: %v", err)
+	}
+
+	journalPath := filepath.Join(tempDir, "journal.jsonl")
+	journal, err := OpenJournal(journalPath, HashMD5)
+	if err != nil {
+		t.Fatalf("OpenJournal returned an error: %v", err)
+	}
+	if err := journal.record(OperationCopy, srcPath, destPath, checksum); err != nil {
+		t.Fatalf("record returned an error: %v", err)
+	}
+	journal.Close()
+
+	report, err := Undo(journalPath, "")
+	if err != nil {
+		t.Fatalf("Undo returned an error: %v", err)
+	}
+	if report.Removed != 1 || len(report.Skipped) != 0 {
+		t.Errorf("Expected 1 removed copy and nothing skipped, got %+v", report)
+	}
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the copy to be removed")
+	}
+	if _, err := os.Stat(srcPath); err != nil {
+		t.Errorf("Expected the original to be left alone: %v", err)
+	}
+}
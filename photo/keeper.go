@@ -0,0 +1,227 @@
+package photo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KeeperRule names a rule a KeeperPolicy uses to choose the file that is organized.
+type KeeperRule string
+
+const (
+	KeepFirstPath KeeperRule = "path"     // The lexicographically smallest source path
+	KeepPriority  KeeperRule = "priority" // The file in the earliest directory of the priority list
+	KeepShortest  KeeperRule = "shortest" // The shortest source path
+	KeepLongest   KeeperRule = "longest"  // The longest source path
+	KeepOldest    KeeperRule = "oldest"   // The oldest modification time
+	KeepNewest    KeeperRule = "newest"   // The newest modification time
+	KeepPattern   KeeperRule = "pattern"  // The file whose name matches the earliest preferred pattern
+)
+
+// KeeperPolicy decides which of several files with identical content is organized; the others
+// are treated as duplicates. Rules are applied in order until one of them prefers a file, and
+// remaining ties are broken by source path, so the choice never depends on the order in which
+// files are seen. The zero value keeps the smallest source path.
+type KeeperPolicy struct {
+	Rules    []KeeperRule
+	Priority []string         // Source directories, most preferred first, used by KeepPriority
+	Patterns []*regexp.Regexp // File name patterns, most preferred first, used by KeepPattern
+}
+
+// ParseKeeperRule returns the KeeperRule with the given name.
+func ParseKeeperRule(name string) (KeeperRule, error) {
+	switch rule := KeeperRule(name); rule {
+	case KeepFirstPath, KeepPriority, KeepShortest, KeepLongest, KeepOldest, KeepNewest, KeepPattern:
+		return rule, nil
+	}
+	return "", fmt.Errorf("unknown keeper rule %q", name)
+}
+
+// prefers reports whether a should be kept over b.
+func (k KeeperPolicy) prefers(a, b PlanEntry) bool {
+	for _, rule := range k.Rules {
+		switch rule {
+		case KeepPriority:
+			if rankA, rankB := k.rank(a.Source), k.rank(b.Source); rankA != rankB {
+				return rankA < rankB
+			}
+		case KeepShortest, KeepLongest:
+			if len(a.Source) != len(b.Source) {
+				return (len(a.Source) < len(b.Source)) == (rule == KeepShortest)
+			}
+		case KeepOldest, KeepNewest:
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime) == (rule == KeepOldest)
+			}
+		case KeepPattern:
+			if matchA, matchB := k.match(a.Source), k.match(b.Source); matchA != matchB {
+				return matchA < matchB
+			}
+		case KeepFirstPath:
+			return a.Source < b.Source
+		}
+	}
+	return a.Source < b.Source
+}
+
+// rank returns the position of the first priority directory containing path, or
+// len(k.Priority) if none does.
+func (k KeeperPolicy) rank(path string) int {
+	for i, dir := range k.Priority {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return i
+		}
+	}
+	return len(k.Priority)
+}
+
+// match returns the position of the first pattern matching the file name of path, or
+// len(k.Patterns) if none does.
+func (k KeeperPolicy) match(path string) int {
+	name := filepath.Base(path)
+	for i, pattern := range k.Patterns {
+		if pattern.MatchString(name) {
+			return i
+		}
+	}
+	return len(k.Patterns)
+}
+
+// processDeterministic organizes files in three steps: every file is inspected in parallel,
+// the originals are chosen by options.Keeper, and the resulting entries are applied in
+// parallel. Neither the decisions nor the log depend on worker scheduling.
+func processDeterministic(
+	ctx context.Context,
+	files []foundFile,
+	destDir string,
+	duplicatesDir string,
+	noDataDir string,
+	registry *registry,
+	logFile io.Writer,
+	state *State,
+	messenger Messenger,
+	options Options,
+) error {
+	var entries []PlanEntry
+	var entriesLock sync.Mutex
+
+	err := forEach(ctx, files, state, func(file foundFile) {
+		state.countStage(stageFull)
+		entry, err := inspectFile(file.path, true, destDir, noDataDir, options.Index, options.Hasher, options.MediaInfo)
+		switch {
+		case errors.Is(err, errAlreadyOrganized):
+			state.IncrementSkipped()
+		case err != nil:
+			state.IncrementError()
+		default:
+			state.countRawFile(entry)
+			// The file is counted as processed once its entry has been applied
+			entriesLock.Lock()
+			entries = append(entries, entry)
+			entriesLock.Unlock()
+			if !state.IsPaused() {
+				state.UpdateMessage("Inspecting ...")
+			}
+			messenger.Send(ProgressTickMsg{})
+			return
+		}
+		state.IncrementProcessed()
+		messenger.Send(ProgressTickMsg{})
+	})
+	if err != nil {
+		return err // Nothing has been decided, so nothing was written
+	}
+
+	entries = chooseKeepers(entries, duplicatesDir, registry, options.Keeper, options.Paranoid)
+
+	if options.Plan == nil {
+		// Records reach the index as files are placed and are put in source order at the end
+		applyEntries(ctx, entries, options.MoveFiles, logFile, "Processing ...", state, messenger, options)
+		if err := options.Index.compact(); err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+
+	for _, entry := range entries {
+		switch entry.Action {
+		case ActionDuplicate:
+			state.IncrementDuplicates()
+		case ActionNoData:
+			state.IncrementNoData()
+		default:
+			state.IncrementUnique()
+		}
+		options.Plan.add(entry)
+		state.AddPlannedBytes(options.Plan.writtenBytes(entry))
+		state.IncrementProcessed()
+	}
+	messenger.Send(ProgressTickMsg{})
+	return nil
+}
+
+// chooseKeepers decides which entry of every set of identical files is organized and turns
+// the others into duplicates. Destinations are reserved in source path order, originals
+// first, so the same input always yields the same destinations. If paranoid is set, a file
+// only becomes a duplicate if it matches its original byte for byte; otherwise it is
+// organized as a hash collision.
+func chooseKeepers(entries []PlanEntry, duplicatesDir string, registry *registry, keeper KeeperPolicy, paranoid bool) []PlanEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Source < entries[j].Source
+	})
+
+	keepers := make(map[string]int) // checksum -> index of the entry to organize
+	for i, entry := range entries {
+		if kept, exists := keepers[entry.Checksum]; !exists || keeper.prefers(entry, entries[kept]) {
+			keepers[entry.Checksum] = i
+		}
+	}
+
+	// reference is the file a duplicate is compared with; nothing has been written yet, so
+	// originals of this run are compared at their source
+	markDuplicate := func(entry *PlanEntry, originalPath, reference string) {
+		if paranoid && !sameContent(entry.Source, reference) {
+			entry.Destination = registry.reserve(entry.Destination)
+			entry.CollidesWith = originalPath
+			return
+		}
+		entry.Action = ActionDuplicate
+		entry.Destination = registry.reserve(filepath.Join(duplicatesDir, filepath.Base(entry.Source)))
+		entry.DuplicateOf = originalPath
+	}
+
+	// Keepers claim their checksum first; content already in the library stays a duplicate
+	for i := range entries {
+		if keepers[entries[i].Checksum] != i {
+			continue
+		}
+		destPath, original, outcome := registry.claim(entries[i], nil)
+		if outcome == claimDuplicate {
+			markDuplicate(&entries[i], original.Destination, original.Destination)
+			continue
+		}
+		entries[i].Destination = destPath
+		registry.settle(entries[i].Checksum)
+	}
+
+	for i := range entries {
+		kept := entries[keepers[entries[i].Checksum]]
+		if kept.Source == entries[i].Source {
+			continue
+		}
+		if kept.Action == ActionDuplicate {
+			markDuplicate(&entries[i], kept.DuplicateOf, kept.DuplicateOf)
+		} else {
+			markDuplicate(&entries[i], kept.Destination, kept.Source)
+		}
+	}
+	return entries
+}
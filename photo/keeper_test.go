@@ -0,0 +1,369 @@
+package photo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestKeeperPolicyPrefers tests the rules for choosing which of two identical files is kept
+func TestKeeperPolicyPrefers(t *testing.T) {
+	older := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	camera := PlanEntry{Source: filepath.Join("src", "Camera", "IMG_1234.JPG"), ModTime: newer}
+	whatsApp := PlanEntry{Source: filepath.Join("src", "WhatsApp Images", "IMG-2019-WA0001.jpg"), ModTime: older}
+	backup := PlanEntry{Source: filepath.Join("src", "Backup", "IMG_1234.JPG"), ModTime: newer}
+
+	tests := []struct {
+		name     string
+		policy   KeeperPolicy
+		a, b     PlanEntry
+		expected bool
+	}{
+		{"path", KeeperPolicy{}, backup, camera, true},
+		{"path reversed", KeeperPolicy{Rules: []KeeperRule{KeepFirstPath}}, camera, backup, false},
+		{"oldest", KeeperPolicy{Rules: []KeeperRule{KeepOldest}}, whatsApp, camera, true},
+		{"oldest tie broken by path", KeeperPolicy{Rules: []KeeperRule{KeepOldest}}, backup, camera, true},
+		{"priority", KeeperPolicy{Rules: []KeeperRule{KeepPriority}, Priority: []string{filepath.Join("src", "Camera")}}, camera, backup, true},
+		{"priority unlisted", KeeperPolicy{Rules: []KeeperRule{KeepPriority}, Priority: []string{filepath.Join("src", "Camera")}}, whatsApp, camera, false},
+		{"priority no prefix match", KeeperPolicy{Rules: []KeeperRule{KeepPriority}, Priority: []string{filepath.Join("src", "Cam")}}, camera, backup, false},
+		{"shortest", KeeperPolicy{Rules: []KeeperRule{KeepShortest}}, camera, whatsApp, true},
+		{"longest", KeeperPolicy{Rules: []KeeperRule{KeepLongest}}, camera, whatsApp, false},
+		{"newest", KeeperPolicy{Rules: []KeeperRule{KeepNewest}}, camera, whatsApp, true},
+		{"pattern", KeeperPolicy{Rules: []KeeperRule{KeepPattern}, Patterns: []*regexp.Regexp{regexp.MustCompile(`^IMG_\d+`)}}, camera, whatsApp, true},
+		{"pattern tie falls through", KeeperPolicy{Rules: []KeeperRule{KeepPattern, KeepLongest}, Patterns: []*regexp.Regexp{regexp.MustCompile(`^IMG_\d+`)}}, camera, backup, false},
+		{"first rule decides", KeeperPolicy{Rules: []KeeperRule{KeepOldest, KeepShortest}}, whatsApp, camera, true},
+	}
+
+	for _, test := range tests {
+		if result := test.policy.prefers(test.a, test.b); result != test.expected {
+			t.Errorf("%s: expected prefers(%s, %s) = %v, got %v", test.name, test.a.Source, test.b.Source, test.expected, result)
+		}
+	}
+
+	if _, err := ParseKeeperRule("largest"); err == nil {
+		t.Errorf("Expected an error for an unknown keeper rule")
+	}
+	if rule, err := ParseKeeperRule("oldest"); err != nil || rule != KeepOldest {
+		t.Errorf("Expected oldest to parse as KeepOldest, got %q, %v", rule, err)
+	}
+}
+
+// TestProcessFilesDeterministic tests that deterministic runs always choose the same original and produce identical trees and logs
+func TestProcessFilesDeterministic(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-files-deterministic")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	createLibrary(t, srcDir, map[string]string{
+		filepath.Join("b", "photo.txt"):         "Shared content",
+		filepath.Join("a", "photo.txt"):         "Shared content",
+		filepath.Join("c", "photo.txt"):         "Shared content",
+		filepath.Join("c", "other.txt"):         "Other content",
+		filepath.Join("a", "nested", "one.txt"): "Other content",
+		filepath.Join("b", "unique.txt"):        "Unique content",
+	})
+
+	// run organizes srcDir into a fresh destination and returns its listing and log
+	run := func(name string, keeper KeeperPolicy) (string, string) {
+		destDir := filepath.Join(tempDir, name)
+		logPath := filepath.Join(tempDir, name+".log")
+		options := Options{Deterministic: true, Keeper: keeper}
+		if err := ProcessFiles(context.Background(), srcDir, destDir, logPath, NewState(6), NewMockMessenger(), options); err != nil {
+			t.Fatalf("ProcessFiles returned an error: %v", err)
+		}
+
+		var listing []string
+		err := filepath.Walk(destDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				content, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				rel, _ := filepath.Rel(destDir, path)
+				listing = append(listing, rel+": "+string(content))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to walk destination directory: %v", err)
+		}
+		sort.Strings(listing)
+
+		logContent, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatalf("Failed to read log file: %v", err)
+		}
+		return strings.Join(listing, "\n"), strings.ReplaceAll(string(logContent), destDir, "<dest>")
+	}
+
+	firstTree, firstLog := run("first", KeeperPolicy{})
+	for i := 0; i < 5; i++ {
+		tree, log := run(fmt.Sprintf("again%d", i), KeeperPolicy{})
+		if tree != firstTree {
+			t.Fatalf("Expected identical destination trees, got:\n%s\nand:\n%s", firstTree, tree)
+		}
+		if log != firstLog {
+			t.Fatalf("Expected identical logs, got:\n%s\nand:\n%s", firstLog, log)
+		}
+	}
+
+	// The smallest source path is organized, the others are duplicates logged in source order
+	expectedLog := "Duplicate detected: " + filepath.Join(srcDir, "b", "photo.txt") + " (duplicate of: " + filepath.Join("<dest>", "nodata", "photo.txt") + ")\n" +
+		"Duplicate detected: " + filepath.Join(srcDir, "c", "other.txt") + " (duplicate of: " + filepath.Join("<dest>", "nodata", "one.txt") + ")\n" +
+		"Duplicate detected: " + filepath.Join(srcDir, "c", "photo.txt") + " (duplicate of: " + filepath.Join("<dest>", "nodata", "photo.txt") + ")\n"
+	if firstLog != expectedLog {
+		t.Errorf("Unexpected log:\n%s\nexpected:\n%s", firstLog, expectedLog)
+	}
+
+	// A priority list overrides the path order
+	_, priorityLog := run("priority", KeeperPolicy{Rules: []KeeperRule{KeepPriority}, Priority: []string{filepath.Join(srcDir, "c")}})
+	if !strings.Contains(priorityLog, "Duplicate detected: "+filepath.Join(srcDir, "a", "nested", "one.txt")) {
+		t.Errorf("Expected the file in the priority directory to be kept, got log:\n%s", priorityLog)
+	}
+}
+
+// TestProcessFilesDeterministicIndex tests that deterministic runs write the same index into the destination
+func TestProcessFilesDeterministicIndex(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-deterministic-index")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	files := make(map[string]string)
+	for i := 0; i < 40; i++ {
+		files[fmt.Sprintf("photo%02d.txt", i)] = fmt.Sprintf("Content %d", i%30)
+	}
+	createLibrary(t, srcDir, files)
+
+	// run organizes srcDir into the same destination and returns its index
+	destDir := filepath.Join(tempDir, "dest")
+	run := func() string {
+		if err := os.RemoveAll(destDir); err != nil {
+			t.Fatalf("Failed to remove destination directory: %v", err)
+		}
+		index, err := OpenIndex(filepath.Join(destDir, IndexFileName), HashMD5)
+		if err != nil {
+			t.Fatalf("OpenIndex returned an error: %v", err)
+		}
+		options := Options{Deterministic: true, Index: index}
+		if err := ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), NewState(len(files)), NewMockMessenger(), options); err != nil {
+			t.Fatalf("ProcessFiles returned an error: %v", err)
+		}
+		if err := index.Close(); err != nil {
+			t.Fatalf("Close returned an error: %v", err)
+		}
+		content, err := os.ReadFile(filepath.Join(destDir, IndexFileName))
+		if err != nil {
+			t.Fatalf("Failed to read index: %v", err)
+		}
+		return string(content)
+	}
+
+	first := run()
+	if lines := strings.Count(first, "\n"); lines != len(files) {
+		t.Errorf("Expected %d index records, got %d", len(files), lines)
+	}
+	for i := 0; i < 3; i++ {
+		if again := run(); again != first {
+			t.Fatalf("Expected identical indexes, got:\n%s\nand:\n%s", first, again)
+		}
+	}
+}
+
+// TestProcessFileKeeperReplace tests that a better file replaces an original organized earlier in the same run
+func TestProcessFileKeeperReplace(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-file-keeper")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	duplicatesDir := filepath.Join(destDir, "duplicates")
+	noDataDir := filepath.Join(destDir, "nodata")
+	whatsApp := filepath.Join(srcDir, "WhatsApp Images", "IMG-2019-WA0001.jpg")
+	camera := filepath.Join(srcDir, "Camera", "IMG_1234.JPG")
+	backup := filepath.Join(srcDir, "Backup", "IMG_1234.JPG")
+	createLibrary(t, srcDir, map[string]string{
+		filepath.Join("WhatsApp Images", "IMG-2019-WA0001.jpg"): "Shared content",
+		filepath.Join("Camera", "IMG_1234.JPG"):                 "Shared content",
+		filepath.Join("Backup", "IMG_1234.JPG"):                 "Shared content",
+	})
+	for _, dir := range []string{duplicatesDir, noDataDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory %s: %v", dir, err)
+		}
+	}
+
+	journalPath := filepath.Join(tempDir, "journal.jsonl")
+	journal, err := OpenJournal(journalPath, HashMD5)
+	if err != nil {
+		t.Fatalf("OpenJournal returned an error: %v", err)
+	}
+	index, err := OpenIndex(filepath.Join(destDir, IndexFileName), HashMD5)
+	if err != nil {
+		t.Fatalf("OpenIndex returned an error: %v", err)
+	}
+	options := Options{
+		Journal: journal,
+		Index:   index,
+		Keeper:  KeeperPolicy{Rules: []KeeperRule{KeepPriority}, Priority: []string{filepath.Join(srcDir, "Camera")}},
+	}
+
+	// The least preferred file is seen first and organized, then replaced by the camera original
+	state := NewState(3)
+	registry := newRegistry(nil, nil, nil)
+	var log strings.Builder
+	for _, path := range []string{whatsApp, camera, backup} {
+		if err := processFile(path, true, destDir, duplicatesDir, noDataDir, registry, &log, state, options); err != nil {
+			t.Fatalf("processFile returned an error for %s: %v", path, err)
+		}
+	}
+	journal.Close()
+	index.Close()
+
+	kept := filepath.Join(noDataDir, "IMG_1234.JPG")
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("Expected the camera file to be organized: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(noDataDir, "IMG-2019-WA0001.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Expected the replaced original to leave the organized tree")
+	}
+	for _, name := range []string{"IMG-2019-WA0001.jpg", "IMG_1234.JPG"} {
+		if _, err := os.Stat(filepath.Join(duplicatesDir, name)); err != nil {
+			t.Errorf("Expected %s in the duplicates directory: %v", name, err)
+		}
+	}
+	if state.GetNoDataCount() != 1 || state.GetDuplicateCount() != 2 {
+		t.Errorf("Expected 1 original and 2 duplicates, got %d and %d", state.GetNoDataCount(), state.GetDuplicateCount())
+	}
+	if !strings.Contains(log.String(), whatsApp+" (duplicate of: "+kept) {
+		t.Errorf("Expected the replaced original to be logged as a duplicate of %s, got:\n%s", kept, log.String())
+	}
+
+	reopened, err := OpenIndex(filepath.Join(destDir, IndexFileName), HashMD5)
+	if err != nil {
+		t.Fatalf("OpenIndex returned an error: %v", err)
+	}
+	if originals := reopened.Originals(); len(originals) != 1 || originals[md5Hex("Shared content")] != kept {
+		t.Errorf("Expected the index to point at %s, got %v", kept, originals)
+	}
+
+	// The replacement is journaled, so the whole run can still be undone
+	report, err := Undo(journalPath, "")
+	if err != nil {
+		t.Fatalf("Undo returned an error: %v", err)
+	}
+	if len(report.Skipped) != 0 {
+		t.Errorf("Expected every entry to be undone, skipped %+v", report.Skipped)
+	}
+	for _, dir := range []string{duplicatesDir, noDataDir} {
+		if files, _ := os.ReadDir(dir); len(files) != 0 {
+			t.Errorf("Expected %s to be empty after undo, found %d files", dir, len(files))
+		}
+	}
+}
+
+// TestProcessFileKeeperReplaceDryRun tests that a replacement during a dry run rewrites the plan
+func TestProcessFileKeeperReplaceDryRun(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-file-keeper-dry-run")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	createLibrary(t, srcDir, map[string]string{
+		"long_name.txt": "Shared content",
+		"other.txt":     "Shared content",
+		"tiny.txt":      "Shared content",
+	})
+
+	plan := NewPlan(srcDir, destDir, false)
+	plan.Duplicates = LinkSkip
+	options := Options{Plan: plan, Keeper: KeeperPolicy{Rules: []KeeperRule{KeepShortest}}, Duplicates: LinkSkip}
+	registry := newRegistry(nil, nil, plan)
+	state := NewState(3)
+	for _, name := range []string{"long_name.txt", "other.txt", "tiny.txt"} {
+		err := processFile(filepath.Join(srcDir, name), true, destDir, filepath.Join(destDir, "duplicates"), filepath.Join(destDir, "nodata"), registry, io.Discard, state, options)
+		if err != nil {
+			t.Fatalf("processFile returned an error: %v", err)
+		}
+	}
+
+	kept := filepath.Join(destDir, "nodata", "tiny.txt")
+	for _, entry := range plan.Entries {
+		switch filepath.Base(entry.Source) {
+		case "tiny.txt":
+			if entry.Action != ActionNoData || entry.Destination != kept {
+				t.Errorf("Expected tiny.txt to be organized at %s, got %s to %s", kept, entry.Action, entry.Destination)
+			}
+		default:
+			if entry.Action != ActionDuplicate || entry.DuplicateOf != kept {
+				t.Errorf("Expected %s to be a duplicate of %s, got %s of %q", entry.Source, kept, entry.Action, entry.DuplicateOf)
+			}
+		}
+	}
+	if totals := plan.Totals(); totals.NoData != 1 || totals.Duplicates != 2 {
+		t.Errorf("Expected 1 no-data entry and 2 duplicates, got %+v", totals)
+	}
+
+	// Skipped duplicates write nothing, including the originals they replaced
+	if planned := state.GetPlannedBytes(); planned != int64(len("Shared content")) || planned != plan.Totals().Bytes {
+		t.Errorf("Expected %d planned bytes, got %d (plan %d)", len("Shared content"), planned, plan.Totals().Bytes)
+	}
+}
+
+// TestProcessFilesKeeperConcurrent tests that concurrent workers end up keeping the preferred file
+func TestProcessFilesKeeperConcurrent(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-files-keeper-concurrent")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	const totalFiles = 40
+	files := make(map[string]string, totalFiles)
+	for i := 0; i < totalFiles; i++ {
+		files[strings.Repeat("x", i+1)+".txt"] = "Shared content"
+	}
+	createLibrary(t, srcDir, files)
+
+	state := NewState(totalFiles)
+	options := Options{Keeper: KeeperPolicy{Rules: []KeeperRule{KeepShortest}}}
+	if err := ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), options); err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+
+	organized, _ := os.ReadDir(filepath.Join(destDir, "nodata"))
+	duplicates, _ := os.ReadDir(filepath.Join(destDir, "duplicates"))
+	if len(organized) != 1 || organized[0].Name() != "x.txt" {
+		t.Errorf("Expected only x.txt to be organized, got %v", organized)
+	}
+	if len(duplicates) != totalFiles-1 {
+		t.Errorf("Expected %d duplicates, got %d", totalFiles-1, len(duplicates))
+	}
+	if state.GetNoDataCount() != 1 || state.GetDuplicateCount() != totalFiles-1 {
+		t.Errorf("Expected 1 original and %d duplicates, got %d and %d", totalFiles-1, state.GetNoDataCount(), state.GetDuplicateCount())
+	}
+}
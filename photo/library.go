@@ -0,0 +1,231 @@
+package photo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// organizedNamePattern matches the checksum prefix that ProcessFiles embeds in organized file
+// names, e.g. "IMG_0001_6cd3556d.jpg" or "IMG_0001_6cd3556d_1.jpg" after a naming conflict.
+var organizedNamePattern = regexp.MustCompile(`_([0-9a-f]{8})(?:_\d+)?(?:\.[^.]*)?$`)
+
+// datePathPattern matches the YYYY/MM/DD directories of an organized library.
+var datePathPattern = regexp.MustCompile(`^\d{4}/\d{2}/\d{2}$`)
+
+// Library holds the checksums of files already present in a destination tree, so that new
+// sources can be deduplicated against everything imported by previous runs. Files whose name
+// carries a checksum prefix are only hashed once a new file with the same prefix shows up.
+// The prefix was written by whichever algorithm organized the file, so with another algorithm
+// than MD5 they are also hashed once a new file of the same size misses its prefix.
+// It is safe for concurrent use.
+type Library struct {
+	mu        sync.Mutex
+	hasher    Hasher
+	checksums map[string]string        // full checksum -> library path
+	hashed    map[string]struct{}      // Library paths whose checksum is in checksums
+	byPrefix  map[string]*libraryGroup // checksum prefix -> library paths hashed on demand
+	bySize    map[int64]*libraryGroup  // size -> library paths whose prefix may be of another algorithm
+	sizes     map[int64]struct{}       // Sizes of all library files
+	size      int
+}
+
+// libraryGroup is a set of library files that are hashed together the first time a lookup
+// needs them. Lookups of the group wait until it has been hashed.
+type libraryGroup struct {
+	hashed sync.Once
+	paths  []string
+}
+
+// ScanLibrary indexes the files in an existing destination tree with checksums of hasher.
+// Files known to index are taken from it, files in the YYYY/MM/DD tree are keyed by the
+// checksum prefix in their name and everything else is hashed. A name prefix is not trusted
+// if the index recorded the file with another algorithm. Files organized without an index may
+// carry MD5 prefixes, so unless hasher is MD5 they are also kept by size. The duplicates directory is not part
+// of the library. The scan holds while state is paused; cancelling ctx aborts it and returns
+// ctx.Err().
+func ScanLibrary(ctx context.Context, destDir string, index *Index, hasher Hasher, state *State, messenger Messenger) (*Library, error) {
+	library := &Library{
+		hasher:    hasher.resolved(),
+		checksums: make(map[string]string),
+		hashed:    make(map[string]struct{}),
+		byPrefix:  make(map[string]*libraryGroup),
+		bySize:    make(map[int64]*libraryGroup),
+		sizes:     make(map[int64]struct{}),
+	}
+
+	state.UpdateMessage("Indexing library ...")
+	messenger.Send(ProgressTickMsg{})
+
+	// Files that have to be hashed are collected first and hashed in parallel
+	var toHash []string
+	duplicatesDir := filepath.Join(destDir, "duplicates")
+
+	err := filepath.Walk(destDir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == destDir {
+			return filepath.SkipAll // Nothing imported yet
+		}
+		if err != nil {
+			return err
+		}
+		if err := state.waitIfPaused(ctx); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path == duplicatesDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") { // Ignore hidden files such as the index
+			return nil
+		}
+
+		library.size++
+		library.sizes[info.Size()] = struct{}{}
+		state.IncrementLibrary()
+
+		if _, known := index.checksumOf(path); known {
+			return nil // Already seeded from the index
+		}
+		if prefix, ok := embeddedChecksum(destDir, path); ok && !index.foreignChecksum(path) {
+			library.byPrefix[prefix] = library.byPrefix[prefix].with(path)
+			if library.hasher != HashMD5 {
+				library.bySize[info.Size()] = library.bySize[info.Size()].with(path)
+			}
+			return nil
+		}
+		toHash = append(toHash, path)
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan library: %w", err)
+	}
+
+	err = forEach(ctx, toHash, state, func(path string) {
+		checksum, err := checksumFile(path, library.hasher)
+		if err != nil {
+			state.IncrementError()
+			return
+		}
+		library.mu.Lock()
+		library.checksums[checksum] = path
+		library.hashed[path] = struct{}{}
+		library.mu.Unlock()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	state.UpdateMessage(fmt.Sprintf("Indexed %d Library Files", library.size))
+	messenger.Send(ProgressTickMsg{})
+	return library, nil
+}
+
+// embeddedChecksum returns the checksum prefix embedded in the name of an organized file.
+// Only files in the YYYY/MM/DD tree are considered, since names elsewhere were not chosen by us.
+func embeddedChecksum(destDir, path string) (string, bool) {
+	rel, err := filepath.Rel(destDir, filepath.Dir(path))
+	if err != nil || !datePathPattern.MatchString(filepath.ToSlash(rel)) {
+		return "", false
+	}
+	match := organizedNamePattern.FindStringSubmatch(filepath.Base(path))
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// Hasher returns the checksum algorithm of the library.
+func (l *Library) Hasher() Hasher {
+	if l == nil {
+		return ""
+	}
+	return l.hasher
+}
+
+// hasSize reports whether any library file has the given size.
+func (l *Library) hasSize(size int64) bool {
+	if l == nil {
+		return false
+	}
+	_, exists := l.sizes[size]
+	return exists
+}
+
+// with returns the group with path added, creating the group if it is nil.
+func (g *libraryGroup) with(path string) *libraryGroup {
+	if g == nil {
+		g = &libraryGroup{}
+	}
+	g.paths = append(g.paths, path)
+	return g
+}
+
+// lookup returns the library file with the given checksum and size. Files whose name carries
+// the same checksum prefix are hashed on demand to confirm the match, and then, if their prefix
+// may be of another algorithm, files of the same size. Concurrent lookups of the same prefix
+// or size wait for their files to be hashed.
+func (l *Library) lookup(checksum string, size int64) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+
+	l.mu.Lock()
+	path, exists := l.checksums[checksum]
+	groups := []*libraryGroup{l.byPrefix[checksum[:8]], l.bySize[size]}
+	l.mu.Unlock()
+
+	for _, group := range groups {
+		if exists || group == nil {
+			continue
+		}
+		group.hashed.Do(func() { l.hash(group.paths) })
+
+		l.mu.Lock()
+		path, exists = l.checksums[checksum]
+		l.mu.Unlock()
+	}
+	return path, exists
+}
+
+// hash hashes library files outside the lock and remembers their checksums, skipping files
+// hashed before. The first file with a checksum is kept if several have the same content.
+func (l *Library) hash(paths []string) {
+	for _, path := range paths {
+		l.mu.Lock()
+		_, done := l.hashed[path]
+		l.mu.Unlock()
+		if done {
+			continue
+		}
+
+		full, err := checksumFile(path, l.hasher)
+		if err != nil {
+			continue
+		}
+		l.mu.Lock()
+		if _, exists := l.checksums[full]; !exists {
+			l.checksums[full] = path
+		}
+		l.hashed[path] = struct{}{}
+		l.mu.Unlock()
+	}
+}
+
+// checksumFile opens a file and computes its checksum with the given algorithm.
+func checksumFile(path string, hasher Hasher) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer file.Close()
+	return calculateChecksum(file, hasher)
+}
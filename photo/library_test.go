@@ -0,0 +1,235 @@
+package photo
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// md5Hex returns the MD5 checksum of content as calculateChecksum would
+func md5Hex(content string) string {
+	sum := md5.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// createLibrary creates a destination tree as left behind by previous runs
+func createLibrary(t *testing.T, destDir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(destDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create library file %s: %v", name, err)
+		}
+	}
+}
+
+// TestEmbeddedChecksum tests the extraction of checksum prefixes from organized file names
+func TestEmbeddedChecksum(t *testing.T) {
+	destDir := "library"
+	tests := []struct {
+		path     string
+		expected string
+		ok       bool
+	}{
+		{filepath.Join(destDir, "2020", "01", "02", "IMG_0001_6cd3556d.jpg"), "6cd3556d", true},
+		{filepath.Join(destDir, "2020", "01", "02", "IMG_0001_6cd3556d_3.jpg"), "6cd3556d", true},
+		{filepath.Join(destDir, "2020", "01", "02", "IMG_0001.jpg"), "", false},
+		{filepath.Join(destDir, "nodata", "IMG_20190101.jpg"), "", false}, // Not a name we chose
+	}
+
+	for _, test := range tests {
+		prefix, ok := embeddedChecksum(destDir, test.path)
+		if prefix != test.expected || ok != test.ok {
+			t.Errorf("embeddedChecksum(%s) = %q, %v, expected %q, %v", test.path, prefix, ok, test.expected, test.ok)
+		}
+	}
+}
+
+// TestScanLibrary tests that existing library files can be found by checksum
+func TestScanLibrary(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-scan-library")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	organized := "Organized content"
+	noData := "No-data content"
+	duplicate := "Duplicate content"
+	organizedName := filepath.Join("2021", "06", "15", "photo_"+md5Hex(organized)[:8]+".jpg")
+
+	createLibrary(t, tempDir, map[string]string{
+		organizedName:                          organized,
+		filepath.Join("nodata", "scan.png"):    noData,
+		filepath.Join("duplicates", "dup.jpg"): duplicate,
+	})
+
+	state := NewState(0)
+	library, err := ScanLibrary(context.Background(), tempDir, nil, HashMD5, state, NewMockMessenger())
+	if err != nil {
+		t.Fatalf("ScanLibrary returned an error: %v", err)
+	}
+
+	if state.GetLibraryCount() != 2 {
+		t.Errorf("Expected 2 library files outside duplicates/, got %d", state.GetLibraryCount())
+	}
+
+	// The organized file is only known by prefix until it is looked up
+	if len(library.checksums) != 1 {
+		t.Errorf("Expected only the no-data file to be hashed during the scan, got %d", len(library.checksums))
+	}
+
+	if path, found := library.lookup(md5Hex(organized), int64(len(organized))); !found || path != filepath.Join(tempDir, organizedName) {
+		t.Errorf("Expected organized file to be found, got %q, %v", path, found)
+	}
+	if path, found := library.lookup(md5Hex(noData), int64(len(noData))); !found || path != filepath.Join(tempDir, "nodata", "scan.png") {
+		t.Errorf("Expected no-data file to be found, got %q, %v", path, found)
+	}
+	if _, found := library.lookup(md5Hex(duplicate), int64(len(duplicate))); found {
+		t.Errorf("Expected files in duplicates/ not to be part of the library")
+	}
+
+	// A missing destination is an empty library
+	emptyState := NewState(0)
+	empty, err := ScanLibrary(context.Background(), filepath.Join(tempDir, "missing"), nil, HashMD5, emptyState, NewMockMessenger())
+	if err != nil {
+		t.Fatalf("ScanLibrary returned an error for a missing destination: %v", err)
+	}
+	if emptyState.GetLibraryCount() != 0 {
+		t.Errorf("Expected an empty library, got %d files", emptyState.GetLibraryCount())
+	}
+	if _, found := empty.lookup(md5Hex(noData), int64(len(noData))); found {
+		t.Errorf("Expected nothing to be found in an empty library")
+	}
+}
+
+// TestLibraryConcurrentLookups tests that lookups sharing a checksum prefix wait for its files to be hashed
+func TestLibraryConcurrentLookups(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-library-lookups")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	content := "Looked up by several workers"
+	checksum := md5Hex(content)
+	createLibrary(t, tempDir, map[string]string{
+		filepath.Join("2021", "06", "15", "photo_"+checksum[:8]+".jpg"): content,
+	})
+	library, err := ScanLibrary(context.Background(), tempDir, nil, HashMD5, NewState(0), NewMockMessenger())
+	if err != nil {
+		t.Fatalf("ScanLibrary returned an error: %v", err)
+	}
+
+	// Another checksum with the same prefix is looked up alongside
+	other := checksum[:8] + strings.Repeat("0", len(checksum)-8)
+	var wg sync.WaitGroup
+	misses := make(chan string, 16)
+	for i := 0; i < 16; i++ {
+		wanted := checksum
+		if i%2 == 1 {
+			wanted = other
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, found := library.lookup(wanted, int64(len(content))); found != (wanted == checksum) {
+				misses <- wanted
+			}
+		}()
+	}
+	wg.Wait()
+	close(misses)
+	for wanted := range misses {
+		t.Errorf("Unexpected lookup result for %s", wanted)
+	}
+}
+
+// TestProcessFilesIntoLibrary tests that sources already present in the library are treated as duplicates
+func TestProcessFilesIntoLibrary(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-files-library")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+
+	existing := "Imported last year"
+	createLibrary(t, destDir, map[string]string{
+		filepath.Join("2020", "01", "02", "old_"+md5Hex(existing)[:8]+".jpg"): existing,
+	})
+	createLibrary(t, srcDir, map[string]string{
+		"again.jpg": existing,
+		"new.jpg":   "Brand new content",
+	})
+
+	state := NewState(2)
+	library, err := ScanLibrary(context.Background(), destDir, nil, HashMD5, state, NewMockMessenger())
+	if err != nil {
+		t.Fatalf("ScanLibrary returned an error: %v", err)
+	}
+
+	err = ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), Options{Library: library})
+	if err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+
+	if state.GetDuplicateCount() != 1 {
+		t.Errorf("Expected 1 duplicate of the library, got %d", state.GetDuplicateCount())
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "duplicates", "again.jpg")); err != nil {
+		t.Errorf("Expected known content to be routed to duplicates/: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "nodata", "new.jpg")); err != nil {
+		t.Errorf("Expected new content to be organized: %v", err)
+	}
+}
+
+// TestScanLibraryPaused tests that the library scan waits while paused
+func TestScanLibraryPaused(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-scan-library-paused")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	createLibrary(t, tempDir, map[string]string{
+		filepath.Join("nodata", "scan.png"): "No-data content",
+	})
+
+	state := NewState(0)
+	state.Pause()
+	done := make(chan error, 1)
+	go func() {
+		_, err := ScanLibrary(context.Background(), tempDir, nil, HashMD5, state, NewMockMessenger())
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Expected ScanLibrary to wait while paused, returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if state.GetLibraryCount() != 0 {
+		t.Errorf("Expected no library files to be counted while paused, got %d", state.GetLibraryCount())
+	}
+
+	state.Resume()
+	if err := <-done; err != nil {
+		t.Fatalf("ScanLibrary returned an error: %v", err)
+	}
+	if state.GetLibraryCount() != 1 {
+		t.Errorf("Expected 1 library file after resuming, got %d", state.GetLibraryCount())
+	}
+}
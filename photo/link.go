@@ -0,0 +1,163 @@
+package photo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// LinkMode decides how a file is placed in the destination instead of being copied in full.
+// The zero value copies.
+type LinkMode string
+
+const (
+	LinkCopy     LinkMode = "copy"     // A full copy
+	LinkHardlink LinkMode = "hardlink" // Another name for the same file; only within a file system
+	LinkSymlink  LinkMode = "symlink"  // A relative symbolic link to the original; duplicates only
+	LinkReflink  LinkMode = "reflink"  // A copy-on-write clone on btrfs or XFS; originals only
+	LinkSkip     LinkMode = "skip"     // Nothing is placed; duplicates only
+)
+
+// ParseDuplicateMode returns the LinkMode with the given name, if duplicates can be placed that way.
+func ParseDuplicateMode(name string) (LinkMode, error) {
+	switch mode := LinkMode(name); mode {
+	case LinkCopy, LinkHardlink, LinkSymlink, LinkSkip:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown duplicate mode %q", name)
+}
+
+// ParseOriginalMode returns the LinkMode with the given name, if originals can be placed that way.
+func ParseOriginalMode(name string) (LinkMode, error) {
+	switch mode := LinkMode(name); mode {
+	case LinkCopy, LinkHardlink, LinkReflink:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown original mode %q", name)
+}
+
+// copies reports whether files are copied in full in this mode.
+func (m LinkMode) copies() bool {
+	return m == "" || m == LinkCopy
+}
+
+// links reports whether duplicates placed in this mode refer to their original, which must be
+// in place first.
+func (m LinkMode) links() bool {
+	return m == LinkHardlink || m == LinkSymlink
+}
+
+// placeDuplicateFile places the duplicate src at dest as selected by mode, linked to the
+// original at originalPath. If the link cannot be made, for example because the original is on
+// another file system, src is copied instead. It returns the operation to journal. An existing
+// dest is never replaced; the error then matches fs.ErrExist.
+func placeDuplicateFile(src, dest, originalPath string, mode LinkMode, preserve Preserve) (string, error) {
+	var err error
+	switch mode {
+	case LinkHardlink:
+		if err = hardlinkFile(originalPath, dest); err == nil {
+			return OperationCopy, nil
+		}
+	case LinkSymlink:
+		if err = symlinkFile(originalPath, dest); err == nil {
+			return OperationSymlink, nil
+		}
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return "", err
+	}
+	if err := copyFile(src, dest, preserve); err != nil {
+		return "", err
+	}
+	return OperationCopy, nil
+}
+
+// placeOriginalFile places the original src at dest as selected by mode. Hardlinks and clones
+// require src and dest to be on the same file system; if they cannot be made, src is copied
+// instead. A hardlink shares the metadata of src, so preserve only applies to clones and copies.
+// An existing dest is never replaced; the error then matches fs.ErrExist.
+func placeOriginalFile(src, dest string, mode LinkMode, preserve Preserve) error {
+	var err error
+	switch mode {
+	case LinkHardlink:
+		err = hardlinkFile(src, dest)
+	case LinkReflink:
+		err = reflinkFile(src, dest, preserve)
+	default:
+		return copyFile(src, dest, preserve)
+	}
+	if err == nil || errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return copyFile(src, dest, preserve)
+}
+
+// hardlinkFile creates dest as another name for target.
+func hardlinkFile(target, dest string) error {
+	if err := os.Link(target, dest); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(dest))
+}
+
+// symlinkFile creates dest as a symbolic link to target, relative to the directory of dest so
+// that the destination tree can be moved as a whole. The target must exist.
+func symlinkFile(target, dest string) error {
+	if _, err := os.Stat(target); err != nil {
+		return err
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+	absDest, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	relative, err := filepath.Rel(filepath.Dir(absDest), absTarget)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(relative, dest); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(dest))
+}
+
+// reflinkFile clones src into a temporary file that shares its data blocks, applies the
+// metadata selected by preserve and renames it to dest, like copyFile does with a copy.
+func reflinkFile(src, dest string, preserve Preserve) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	sourceInfo, err := sourceFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := createTempFile(filepath.Dir(dest))
+	if err != nil {
+		return err
+	}
+	err = cloneFile(tempFile, sourceFile)
+	if err == nil {
+		err = finishTempFile(tempFile, sourceInfo.Mode())
+	} else {
+		tempFile.Close()
+	}
+	if err == nil {
+		err = preserveMetadata(src, sourceInfo, tempFile.Name(), preserve)
+	}
+	if err == nil {
+		err = renameTempFile(tempFile.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+	}
+	return err
+}
@@ -0,0 +1,16 @@
+//go:build linux
+
+package photo
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dest a copy-on-write clone of src with the FICLONE ioctl, which makes a file
+// share all data blocks of another. File systems without shared extents, such as ext4, return
+// an error.
+func cloneFile(dest, src *os.File) error {
+	return unix.IoctlFileClone(int(dest.Fd()), int(src.Fd()))
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package photo
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile returns errors.ErrUnsupported, as clones are only made on Linux.
+func cloneFile(dest, src *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,205 @@
+package photo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestParseLinkModes tests that each kind of file only accepts the modes it supports
+func TestParseLinkModes(t *testing.T) {
+	for _, name := range []string{"copy", "hardlink", "symlink", "skip"} {
+		if mode, err := ParseDuplicateMode(name); err != nil || string(mode) != name {
+			t.Errorf("Expected duplicate mode %s, got %q, %v", name, mode, err)
+		}
+	}
+	for _, name := range []string{"copy", "hardlink", "reflink"} {
+		if mode, err := ParseOriginalMode(name); err != nil || string(mode) != name {
+			t.Errorf("Expected original mode %s, got %q, %v", name, mode, err)
+		}
+	}
+	if _, err := ParseDuplicateMode("reflink"); err == nil {
+		t.Errorf("Expected an error for reflinked duplicates")
+	}
+	if _, err := ParseOriginalMode("symlink"); err == nil {
+		t.Errorf("Expected an error for symlinked originals")
+	}
+}
+
+// TestProcessFilesDuplicateModes tests that duplicates are copied, linked to their original or skipped
+func TestProcessFilesDuplicateModes(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-duplicate-modes")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	createLibrary(t, srcDir, map[string]string{
+		"a.jpg": "Same content",
+		"b.jpg": "Same content",
+	})
+
+	for _, mode := range []LinkMode{LinkCopy, LinkHardlink, LinkSymlink, LinkSkip} {
+		for _, deterministic := range []bool{false, true} {
+			destDir := filepath.Join(tempDir, "dest")
+			journalPath := filepath.Join(tempDir, "journal.jsonl")
+			journal, err := OpenJournal(journalPath, HashMD5)
+			if err != nil {
+				t.Fatalf("Failed to open journal: %v", err)
+			}
+
+			options := Options{Journal: journal, Deterministic: deterministic, Duplicates: mode}
+			state := NewState(2)
+			err = ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), options)
+			journal.Close()
+			if err != nil {
+				t.Fatalf("%s: ProcessFiles returned an error: %v", mode, err)
+			}
+			if state.GetDuplicateCount() != 1 {
+				t.Errorf("%s: Expected 1 duplicate, got %d", mode, state.GetDuplicateCount())
+			}
+
+			duplicates, _ := os.ReadDir(filepath.Join(destDir, "duplicates"))
+			originals, _ := os.ReadDir(filepath.Join(destDir, "nodata"))
+			if len(originals) != 1 {
+				t.Fatalf("%s: Expected 1 original, found %d", mode, len(originals))
+			}
+			originalPath := filepath.Join(destDir, "nodata", originals[0].Name())
+
+			if mode == LinkSkip {
+				if len(duplicates) != 0 {
+					t.Errorf("%s: Expected no duplicate to be placed, found %d", mode, len(duplicates))
+				}
+			} else if len(duplicates) != 1 {
+				t.Errorf("%s: Expected 1 duplicate to be placed, found %d", mode, len(duplicates))
+			} else {
+				duplicatePath := filepath.Join(destDir, "duplicates", duplicates[0].Name())
+				if content, err := os.ReadFile(duplicatePath); err != nil || string(content) != "Same content" {
+					t.Errorf("%s: Expected the duplicate content, got %q, %v", mode, content, err)
+				}
+				linkInfo, _ := os.Lstat(duplicatePath)
+				if isLink := linkInfo.Mode()&os.ModeSymlink != 0; isLink != (mode == LinkSymlink) {
+					t.Errorf("%s: Expected symbolic link = %v, got mode %v", mode, mode == LinkSymlink, linkInfo.Mode())
+				}
+				if target, err := os.Readlink(duplicatePath); mode == LinkSymlink && (err != nil || filepath.IsAbs(target)) {
+					t.Errorf("%s: Expected a relative link, got %q, %v", mode, target, err)
+				}
+				originalInfo, _ := os.Stat(originalPath)
+				duplicateInfo, _ := os.Stat(duplicatePath)
+				if shared := os.SameFile(originalInfo, duplicateInfo); shared != (mode != LinkCopy) {
+					t.Errorf("%s: Expected the duplicate to share the original = %v, got %v", mode, mode != LinkCopy, shared)
+				}
+			}
+
+			// Links are reversed like copies
+			report, err := Undo(journalPath, "")
+			if err != nil {
+				t.Fatalf("%s: Undo returned an error: %v", mode, err)
+			}
+			if len(report.Skipped) != 0 {
+				t.Errorf("%s: Expected every entry to be reversed, got %+v", mode, report.Skipped)
+			}
+			os.RemoveAll(destDir)
+			os.Remove(journalPath)
+		}
+	}
+}
+
+// TestPlaceOriginalFile tests hardlinked and cloned originals, and the fallback to a copy
+func TestPlaceOriginalFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-place-original-file")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcPath := filepath.Join(tempDir, "source.jpg")
+	createLibrary(t, tempDir, map[string]string{"source.jpg": "Organized without a copy"})
+	srcInfo, _ := os.Stat(srcPath)
+
+	for _, mode := range []LinkMode{LinkHardlink, LinkReflink, LinkCopy} {
+		destPath := filepath.Join(tempDir, string(mode)+".jpg")
+		if err := placeOriginalFile(srcPath, destPath, mode, Preserve{}); err != nil {
+			t.Fatalf("%s: placeOriginalFile returned an error: %v", mode, err)
+		}
+		if content, err := os.ReadFile(destPath); err != nil || string(content) != "Organized without a copy" {
+			t.Errorf("%s: Expected the source content, got %q, %v", mode, content, err)
+		}
+		destInfo, _ := os.Stat(destPath)
+		if shared := os.SameFile(srcInfo, destInfo); shared != (mode == LinkHardlink) {
+			t.Errorf("%s: Expected the same file = %v, got %v", mode, mode == LinkHardlink, shared)
+		}
+	}
+
+	// A hardlink to another file system is impossible, so the file is copied
+	otherDir, err := os.MkdirTemp("/dev/shm", "test-place-original-file")
+	if err != nil {
+		t.Skip("No second file system available")
+	}
+	defer os.RemoveAll(otherDir)
+	destPath := filepath.Join(otherDir, "photo.jpg")
+	if err := placeOriginalFile(srcPath, destPath, LinkHardlink, Preserve{}); err != nil {
+		t.Fatalf("placeOriginalFile returned an error across file systems: %v", err)
+	}
+	if content, err := os.ReadFile(destPath); err != nil || string(content) != "Organized without a copy" {
+		t.Errorf("Expected the source to be copied, got %q, %v", content, err)
+	}
+}
+
+// TestApplyPlanWithLinks tests that a plan keeps its link modes and links duplicates once their original is in place
+func TestApplyPlanWithLinks(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-apply-plan-links")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	createLibrary(t, srcDir, map[string]string{
+		"a.jpg": "Same content",
+		"b.jpg": "Same content",
+	})
+
+	plan := NewPlan(srcDir, destDir, false)
+	options := Options{Plan: plan, Duplicates: LinkHardlink, Originals: LinkHardlink}
+	state := NewState(2)
+	if err := ProcessFiles(context.Background(), srcDir, destDir, "", state, NewMockMessenger(), options); err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+	if plan.Totals().Bytes != 0 || state.GetPlannedBytes() != 0 {
+		t.Errorf("Expected no bytes to be copied, got %d and %d", plan.Totals().Bytes, state.GetPlannedBytes())
+	}
+
+	planPath := filepath.Join(tempDir, "plan.json")
+	if err := plan.WriteFile(planPath); err != nil {
+		t.Fatalf("Failed to write plan: %v", err)
+	}
+	loaded, err := LoadPlan(planPath)
+	if err != nil {
+		t.Fatalf("Failed to load plan: %v", err)
+	}
+
+	// The modes of the plan win over those of the options
+	if err := ApplyPlan(context.Background(), loaded, filepath.Join(tempDir, "test.log"), NewState(2), NewMockMessenger(), Options{}); err != nil {
+		t.Fatalf("ApplyPlan returned an error: %v", err)
+	}
+	// The original is a hardlink of its source, and the duplicate one of the original
+	var srcInfo os.FileInfo
+	for _, entry := range loaded.Entries {
+		if entry.Action != ActionDuplicate {
+			srcInfo, _ = os.Stat(entry.Source)
+		}
+	}
+	for _, entry := range loaded.Entries {
+		info, err := os.Stat(entry.Destination)
+		if err != nil {
+			t.Fatalf("Expected %s to be placed: %v", entry.Destination, err)
+		}
+		if !os.SameFile(srcInfo, info) {
+			t.Errorf("Expected %s to be a hardlink of the original's source", entry.Destination)
+		}
+	}
+}
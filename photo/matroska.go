@@ -0,0 +1,144 @@
+package photo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// Matroska element IDs, with their length marker bits kept as the specification lists them.
+const (
+	ebmlHeaderID      = 0x1A45DFA3
+	matroskaSegmentID = 0x18538067
+	matroskaInfoID    = 0x1549A966
+	matroskaDateID    = 0x4461 // DateUTC, in the segment information
+	matroskaClusterID = 0x1F43B675
+)
+
+// matroskaEpoch is the origin of Matroska dates.
+var matroskaEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ebmlElement is an element of a Matroska (MKV) or WebM file: an ID and a payload.
+type ebmlElement struct {
+	id     uint64
+	offset int64 // Start of the payload in the file
+	size   int64 // Size of the payload, -1 if unknown as in live recordings
+}
+
+// readVint reads a variable-length integer of EBML at offset and returns it with its length.
+// The length marker is kept for IDs and removed for sizes; a size with all value bits set is
+// unknown and returned as -1.
+func readVint(r io.ReaderAt, offset int64, isID bool) (int64, int, error) {
+	var data [8]byte
+	if _, err := r.ReadAt(data[:1], offset); err != nil {
+		return 0, 0, err
+	}
+	length := bits.LeadingZeros8(data[0]) + 1
+	if length > 8 || isID && length > 4 {
+		return 0, 0, fmt.Errorf("invalid EBML integer at offset %d", offset)
+	}
+	if _, err := r.ReadAt(data[1:length], offset+1); err != nil {
+		return 0, 0, err
+	}
+
+	var value uint64
+	for _, b := range data[:length] {
+		value = value<<8 | uint64(b)
+	}
+	if isID {
+		return int64(value), length, nil
+	}
+	mask := uint64(1)<<(7*length) - 1
+	if value&mask == mask {
+		return -1, length, nil
+	}
+	return int64(value & mask), length, nil
+}
+
+// readEBMLElement reads the header of the element at offset.
+func readEBMLElement(r io.ReaderAt, offset int64) (ebmlElement, error) {
+	id, idLength, err := readVint(r, offset, true)
+	if err != nil {
+		return ebmlElement{}, err
+	}
+	size, sizeLength, err := readVint(r, offset+int64(idLength), false)
+	if err != nil {
+		return ebmlElement{}, err
+	}
+	return ebmlElement{id: uint64(id), offset: offset + int64(idLength+sizeLength), size: size}, nil
+}
+
+// getMatroskaCreationDate reads the creation date of an MKV file of the given size from the
+// DateUTC element of its segment information, which muxers set to the time of the recording.
+func getMatroskaCreationDate(r io.ReaderAt, size int64) (time.Time, error) {
+	header, err := readEBMLElement(r, 0)
+	if err != nil || header.id != ebmlHeaderID || header.size < 0 {
+		return time.Time{}, errors.New("not a Matroska file")
+	}
+
+	for offset := header.offset + header.size; offset < size; {
+		element, err := readEBMLElement(r, offset)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if element.id == matroskaSegmentID {
+			return matroskaSegmentDate(r, element, size)
+		}
+		if element.size < 0 {
+			break
+		}
+		offset = element.offset + element.size
+	}
+	return time.Time{}, errors.New("no Matroska segment found")
+}
+
+// matroskaSegmentDate looks for the DateUTC element in the segment information, which comes
+// before the clusters of sample data.
+func matroskaSegmentDate(r io.ReaderAt, segment ebmlElement, size int64) (time.Time, error) {
+	end := size
+	if segment.size >= 0 && segment.offset+segment.size < end {
+		end = segment.offset + segment.size
+	}
+
+	for offset := segment.offset; offset < end; {
+		element, err := readEBMLElement(r, offset)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if element.id == matroskaInfoID && element.size >= 0 {
+			return matroskaInfoDate(r, element)
+		}
+		// Elements of unknown size cannot be skipped, and clusters hold no metadata
+		if element.size < 0 || element.id == matroskaClusterID {
+			break
+		}
+		offset = element.offset + element.size
+	}
+	return time.Time{}, errNoVideoDate
+}
+
+// matroskaInfoDate reads DateUTC, nanoseconds since 2001, from the segment information.
+func matroskaInfoDate(r io.ReaderAt, info ebmlElement) (time.Time, error) {
+	for offset := info.offset; offset < info.offset+info.size; {
+		element, err := readEBMLElement(r, offset)
+		if err != nil || element.size < 0 {
+			return time.Time{}, errNoVideoDate
+		}
+		if element.id == matroskaDateID && element.size == 8 {
+			var data [8]byte
+			if _, err := r.ReadAt(data[:], element.offset); err != nil {
+				return time.Time{}, err
+			}
+			nanoseconds := int64(binary.BigEndian.Uint64(data[:]))
+			if nanoseconds == 0 { // Unset by the muxer
+				return time.Time{}, errNoVideoDate
+			}
+			return matroskaEpoch.Add(time.Duration(nanoseconds)), nil
+		}
+		offset = element.offset + element.size
+	}
+	return time.Time{}, errNoVideoDate
+}
@@ -0,0 +1,65 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// ebml returns a Matroska element with the given ID bytes around the concatenated payloads,
+// with its size written in eight bytes as muxers that reserve space do
+func ebml(id []byte, payloads ...[]byte) []byte {
+	payload := bytes.Join(payloads, nil)
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(payload)))
+	size[0] = 0x01 // Length marker
+	return bytes.Join([][]byte{id, size[:], payload}, nil)
+}
+
+// matroskaFile returns an MKV file whose segment information records created, or nothing if
+// created is zero, followed by a cluster of sample data
+func matroskaFile(created time.Time, unknownSize bool) []byte {
+	header := ebml([]byte{0x1A, 0x45, 0xDF, 0xA3}, ebml([]byte{0x42, 0x82}, []byte("matroska")))
+
+	var info [][]byte
+	info = append(info, ebml([]byte{0x2A, 0xD7, 0xB1}, []byte{0x0F, 0x42, 0x40})) // TimestampScale
+	if !created.IsZero() {
+		var date [8]byte
+		binary.BigEndian.PutUint64(date[:], uint64(created.Sub(matroskaEpoch)))
+		info = append(info, ebml([]byte{0x44, 0x61}, date[:]))
+	}
+	seekHead := ebml([]byte{0x11, 0x4D, 0x9B, 0x74}, make([]byte, 32))
+	segmentInfo := ebml([]byte{0x15, 0x49, 0xA9, 0x66}, info...)
+	cluster := ebml([]byte{0x1F, 0x43, 0xB6, 0x75}, bytes.Repeat([]byte{0x42}, 4096))
+
+	segment := ebml([]byte{0x18, 0x53, 0x80, 0x67}, seekHead, segmentInfo, cluster)
+	if unknownSize {
+		// Live recordings cannot know the size of their segment in advance
+		copy(segment[4:12], []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	}
+	return append(header, segment...)
+}
+
+// TestGetMatroskaCreationDate tests the DateUTC read from synthetic MKV files
+func TestGetMatroskaCreationDate(t *testing.T) {
+	created := time.Date(2009, 8, 7, 6, 5, 4, 0, time.UTC)
+
+	for _, unknownSize := range []bool{false, true} {
+		content := matroskaFile(created, unknownSize)
+		date, err := getMatroskaCreationDate(bytes.NewReader(content), int64(len(content)))
+		if err != nil || !date.Equal(created) {
+			t.Errorf("Unknown size %v: Expected %v, got %v, %v", unknownSize, created, date, err)
+		}
+	}
+
+	content := matroskaFile(time.Time{}, false)
+	if _, err := getMatroskaCreationDate(bytes.NewReader(content), int64(len(content))); !errors.Is(err, errNoVideoDate) {
+		t.Errorf("Expected errNoVideoDate without DateUTC, got %v", err)
+	}
+	content = []byte("This is not a valid video file")
+	if date, err := getMatroskaCreationDate(bytes.NewReader(content), int64(len(content))); err == nil {
+		t.Errorf("Expected an error for an invalid file, got %v", date)
+	}
+}
@@ -0,0 +1,37 @@
+package photo
+
+import (
+	"fmt"
+	"os"
+)
+
+// Preserve selects the metadata that copies keep besides the permission bits.
+type Preserve struct {
+	Times  bool // Access and modification times
+	Owner  bool // User and group, which usually requires running as root
+	Xattrs bool // Extended attributes, on Linux only; attributes the destination rejects are skipped
+}
+
+// DefaultPreserve keeps everything that can be kept without privileges.
+var DefaultPreserve = Preserve{Times: true, Xattrs: true}
+
+// preserveMetadata applies the metadata of the source file src, described by info, to dest.
+// Times are applied last, since changing the other metadata does not touch them.
+func preserveMetadata(src string, info os.FileInfo, dest string, preserve Preserve) error {
+	if preserve.Owner {
+		if err := copyOwner(info, dest); err != nil {
+			return fmt.Errorf("failed to preserve owner: %w", err)
+		}
+	}
+	if preserve.Xattrs {
+		if err := copyXattrs(src, dest); err != nil {
+			return fmt.Errorf("failed to preserve extended attributes: %w", err)
+		}
+	}
+	if preserve.Times {
+		if err := os.Chtimes(dest, accessTime(info), info.ModTime()); err != nil {
+			return fmt.Errorf("failed to preserve times: %w", err)
+		}
+	}
+	return nil
+}
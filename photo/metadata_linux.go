@@ -0,0 +1,81 @@
+package photo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// accessTime returns the access time of a file, or its modification time if it is unknown.
+func accessTime(info os.FileInfo) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(stat.Atim.Unix())
+}
+
+// copyXattrs copies the extended attributes of src to dest. Attributes that the destination
+// does not support or that need privileges, such as those in the security namespace, are skipped.
+func copyXattrs(src, dest string) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		if xattrSkipped(err) {
+			return nil
+		}
+		return err
+	}
+	for _, name := range names {
+		value, err := getXattr(src, name)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if err := syscall.Setxattr(dest, name, value, 0); err != nil && !xattrSkipped(err) {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// listXattrs returns the names of the extended attributes of path.
+func listXattrs(path string) ([]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// getXattr returns the value of an extended attribute of path.
+func getXattr(path, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	value := make([]byte, size)
+	size, err = syscall.Getxattr(path, name, value)
+	if err != nil {
+		return nil, err
+	}
+	return value[:size], nil
+}
+
+// xattrSkipped reports whether an extended attribute error means the attribute cannot be kept
+// rather than that copying failed.
+func xattrSkipped(err error) bool {
+	return errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,76 @@
+package photo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestCopyFilePreserveXattrs tests that extended attributes are copied only when asked to
+func TestCopyFilePreserveXattrs(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-preserve-xattrs")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcPath := filepath.Join(tempDir, "source.jpg")
+	createLibrary(t, tempDir, map[string]string{"source.jpg": "Tagged photo"})
+	if err := syscall.Setxattr(srcPath, "user.dedupe.test", []byte("family"), 0); err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			t.Skip("The file system does not support extended attributes")
+		}
+		t.Fatalf("Failed to set extended attribute: %v", err)
+	}
+
+	for _, preserve := range []Preserve{{Xattrs: true}, {}} {
+		destPath := filepath.Join(tempDir, "copy.jpg")
+		os.Remove(destPath) // Copies never replace an existing file
+		if err := copyFile(srcPath, destPath, preserve); err != nil {
+			t.Fatalf("copyFile returned an error: %v", err)
+		}
+		value, err := getXattr(destPath, "user.dedupe.test")
+		if preserve.Xattrs && (err != nil || string(value) != "family") {
+			t.Errorf("Expected the attribute to be copied, got %q, %v", value, err)
+		}
+		if !preserve.Xattrs && err == nil {
+			t.Errorf("Expected no attribute without Xattrs, got %q", value)
+		}
+	}
+}
+
+// TestCopyFilePreserveOwner tests that copies keep the owner of their source when asked to
+func TestCopyFilePreserveOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing the owner requires root")
+	}
+	tempDir, err := os.MkdirTemp("", "test-preserve-owner")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcPath := filepath.Join(tempDir, "source.jpg")
+	createLibrary(t, tempDir, map[string]string{"source.jpg": "Someone else's photo"})
+	if err := os.Chown(srcPath, 4321, 8765); err != nil {
+		t.Fatalf("Failed to change the source owner: %v", err)
+	}
+
+	for _, preserve := range []Preserve{{Owner: true}, {}} {
+		destPath := filepath.Join(tempDir, "copy.jpg")
+		os.Remove(destPath) // Copies never replace an existing file
+		if err := copyFile(srcPath, destPath, preserve); err != nil {
+			t.Fatalf("copyFile returned an error: %v", err)
+		}
+		info, err := os.Stat(destPath)
+		if err != nil {
+			t.Fatalf("Failed to stat copy: %v", err)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		if (stat.Uid == 4321 && stat.Gid == 8765) != preserve.Owner {
+			t.Errorf("Expected owner kept = %v, got %d:%d", preserve.Owner, stat.Uid, stat.Gid)
+		}
+	}
+}
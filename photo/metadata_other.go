@@ -0,0 +1,18 @@
+//go:build !linux
+
+package photo
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime returns the modification time, as the access time is not read on this platform.
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
+
+// copyXattrs does nothing, as extended attributes are only copied on Linux.
+func copyXattrs(src, dest string) error {
+	return nil
+}
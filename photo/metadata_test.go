@@ -0,0 +1,53 @@
+package photo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestCopyFilePreserveTimes tests that copies keep the times of their source only when asked to
+func TestCopyFilePreserveTimes(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-preserve-times")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcPath := filepath.Join(tempDir, "source.jpg")
+	createLibrary(t, tempDir, map[string]string{"source.jpg": "Taken in 2015"})
+	accessed := time.Date(2016, 5, 6, 7, 8, 9, 0, time.UTC)
+	modified := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		preserve Preserve
+		kept     bool
+	}{
+		{"kept.jpg", Preserve{Times: true}, true},
+		{"default.jpg", DefaultPreserve, true},
+		{"dropped.jpg", Preserve{}, false},
+	}
+	for _, test := range tests {
+		// Reading the source may update its access time, so it is reset for every copy
+		if err := os.Chtimes(srcPath, accessed, modified); err != nil {
+			t.Fatalf("Failed to set source times: %v", err)
+		}
+		destPath := filepath.Join(tempDir, test.name)
+		if err := copyFile(srcPath, destPath, test.preserve); err != nil {
+			t.Fatalf("copyFile returned an error: %v", err)
+		}
+		info, err := os.Stat(destPath)
+		if err != nil {
+			t.Fatalf("Failed to stat copy: %v", err)
+		}
+		if info.ModTime().Equal(modified) != test.kept {
+			t.Errorf("%s: Expected modification time kept = %v, got %v", test.name, test.kept, info.ModTime())
+		}
+		// Systems without access times report the modification time instead
+		if test.kept && !accessTime(info).Equal(accessed) && !accessTime(info).Equal(modified) {
+			t.Errorf("%s: Expected access time %v, got %v", test.name, accessed, accessTime(info))
+		}
+	}
+}
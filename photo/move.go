@@ -0,0 +1,38 @@
+package photo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errSourceNotRemoved is returned when a file was copied to another file system and verified,
+// but its source could not be removed, as on a write-protected memory card. The copy is kept,
+// so the file ends up in both places, as if it had been copied.
+var errSourceNotRemoved = errors.New("source not removed")
+
+// moveAcrossDevices moves a file to another file system by copying it with the metadata
+// selected by preserve. Before the source is removed, the copy is compared with checksum, or
+// with the source itself if checksum is empty. If the source cannot be removed, the copy is
+// kept and an error matching errSourceNotRemoved is returned.
+func moveAcrossDevices(src, dest, checksum string, hasher Hasher, preserve Preserve) error {
+	if err := copyFile(src, dest, preserve); err != nil {
+		return err
+	}
+
+	verified := false
+	if checksum == "" {
+		verified = sameContent(src, dest)
+	} else if copied, err := checksumFile(dest, hasher); err == nil {
+		verified = copied == checksum
+	}
+	if !verified {
+		os.Remove(dest)
+		return fmt.Errorf("copy across file systems does not match the source, the source was kept")
+	}
+
+	if err := os.Remove(src); err != nil {
+		return fmt.Errorf("%w, the verified copy was kept: %v", errSourceNotRemoved, err)
+	}
+	return nil
+}
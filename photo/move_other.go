@@ -0,0 +1,13 @@
+//go:build !windows
+
+package photo
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isCrossDevice reports whether err is the failure of a rename between file systems.
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
@@ -0,0 +1,168 @@
+package photo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMoveAcrossDevices tests that a copied source is only removed once the copy is verified,
+// and that the copy keeps the metadata selected by the preserve options
+func TestMoveAcrossDevices(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-move-across-devices")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	content := "Photo on an SD card"
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name     string
+		checksum string
+		preserve Preserve
+		moved    bool
+	}{
+		{"verified", md5Hex(content), DefaultPreserve, true},
+		{"compared", "", DefaultPreserve, true},
+		{"without times", md5Hex(content), Preserve{}, true},
+		{"mismatch", md5Hex("Something else"), DefaultPreserve, false},
+	}
+	for _, test := range tests {
+		src := filepath.Join(tempDir, test.name+".jpg")
+		dest := filepath.Join(tempDir, "dest", test.name+".jpg")
+		createLibrary(t, tempDir, map[string]string{test.name + ".jpg": content, filepath.Join("dest", ".keep"): ""})
+		if err := os.Chtimes(src, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set modification time: %v", err)
+		}
+
+		err := moveAcrossDevices(src, dest, test.checksum, HashMD5, test.preserve)
+		_, srcErr := os.Stat(src)
+		destInfo, destErr := os.Stat(dest)
+		if !test.moved {
+			if err == nil || srcErr != nil || destErr == nil {
+				t.Errorf("%s: Expected an error with the source kept and no copy, got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: moveAcrossDevices returned an error: %v", test.name, err)
+		}
+		if !os.IsNotExist(srcErr) || destErr != nil {
+			t.Errorf("%s: Expected the file to be moved", test.name)
+			continue
+		}
+		if destInfo.ModTime().Equal(modTime) != test.preserve.Times {
+			t.Errorf("%s: Expected the modification time to be kept only with Times, got %v", test.name, destInfo.ModTime())
+		}
+	}
+}
+
+// TestMoveAcrossDevicesRemoveFails tests that the verified copy of a source that cannot be
+// removed, as on a write-protected card, is kept and reported
+func TestMoveAcrossDevicesRemoveFails(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Directory permissions are not enforced for root")
+	}
+	tempDir, err := os.MkdirTemp("", "test-move-remove-fails")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	createLibrary(t, tempDir, map[string]string{filepath.Join("card", "photo.jpg"): "Read-only card"})
+	cardDir := filepath.Join(tempDir, "card")
+	if err := os.Chmod(cardDir, 0555); err != nil {
+		t.Fatalf("Failed to make the source directory read-only: %v", err)
+	}
+	defer os.Chmod(cardDir, 0755)
+
+	dest := filepath.Join(tempDir, "photo.jpg")
+	err = moveAcrossDevices(filepath.Join(cardDir, "photo.jpg"), dest, md5Hex("Read-only card"), HashMD5, DefaultPreserve)
+	if !errors.Is(err, errSourceNotRemoved) {
+		t.Errorf("Expected errSourceNotRemoved, got %v", err)
+	}
+	if copied, err := os.ReadFile(dest); err != nil || string(copied) != "Read-only card" {
+		t.Errorf("Expected the verified copy to be kept, got %q, %v", copied, err)
+	}
+	if _, err := os.Stat(filepath.Join(cardDir, "photo.jpg")); err != nil {
+		t.Errorf("Expected the source to be kept: %v", err)
+	}
+}
+
+// TestMoveFileCrossDevice tests moving between file systems, if the system has a second one
+func TestMoveFileCrossDevice(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-move-cross-device")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	otherDir, err := os.MkdirTemp("/dev/shm", "test-move-cross-device")
+	if err != nil {
+		t.Skip("No second file system available")
+	}
+	defer os.RemoveAll(otherDir)
+
+	content := "Imported from another disk"
+	src := filepath.Join(tempDir, "photo.jpg")
+	createLibrary(t, tempDir, map[string]string{"photo.jpg": content})
+	probe := filepath.Join(otherDir, "probe")
+	if err := os.Rename(src, probe); err == nil || !isCrossDevice(err) {
+		t.Skip("/dev/shm is on the same file system")
+	}
+
+	dest := filepath.Join(otherDir, "photo.jpg")
+	if err := moveFile(src, dest, md5Hex(content), HashMD5, DefaultPreserve); err != nil {
+		t.Fatalf("moveFile returned an error: %v", err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("Expected the source to be removed")
+	}
+	if copied, err := os.ReadFile(dest); err != nil || string(copied) != content {
+		t.Errorf("Expected the moved content, got %q, %v", copied, err)
+	}
+}
+
+// TestRecordOriginalSourceNotRemoved tests that a move that left its source behind is journaled
+// as a copy, so that undo removes the copy instead of restoring over the source
+func TestRecordOriginalSourceNotRemoved(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-record-not-removed")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	journalPath := filepath.Join(tempDir, "journal.jsonl")
+	journal, err := OpenJournal(journalPath, HashMD5)
+	if err != nil {
+		t.Fatalf("OpenJournal returned an error: %v", err)
+	}
+	index, err := OpenIndex(filepath.Join(tempDir, IndexFileName), HashMD5)
+	if err != nil {
+		t.Fatalf("OpenIndex returned an error: %v", err)
+	}
+	defer index.Close()
+
+	entry := PlanEntry{Source: "card/photo.jpg", Destination: filepath.Join(tempDir, "photo.jpg"), Checksum: md5Hex("Photo")}
+	placeErr := fmt.Errorf("failed to move file %s: %w", entry.Source, errSourceNotRemoved)
+	err = recordOriginal(entry, placeErr, Options{MoveFiles: true, Journal: journal, Index: index})
+	if !errors.Is(err, errSourceNotRemoved) {
+		t.Errorf("Expected errSourceNotRemoved, got %v", err)
+	}
+	journal.Close()
+
+	entries, err := ReadJournal(journalPath)
+	if err != nil {
+		t.Fatalf("ReadJournal returned an error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Operation != OperationCopy {
+		t.Errorf("Expected the file to be journaled as a copy, got %+v", entries)
+	}
+	if checksum, found := index.checksumOf(entry.Destination); !found || checksum != entry.Checksum {
+		t.Errorf("Expected the copy to be indexed, got %q, %v", checksum, found)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package photo
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errorNotSameDevice is ERROR_NOT_SAME_DEVICE, returned when a file is renamed to another volume.
+const errorNotSameDevice syscall.Errno = 17
+
+// isCrossDevice reports whether err is the failure of a rename between volumes.
+func isCrossDevice(err error) bool {
+	return errors.Is(err, errorNotSameDevice)
+}
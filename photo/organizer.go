@@ -1,12 +1,14 @@
 package photo
 
 import (
-	"crypto/md5"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/cajax/yami"
 	"github.com/rwcarlsen/goexif/exif"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -23,13 +25,35 @@ type State struct {
 	message    string
 	duplicates int
 	errorCount int
-	noData     int // Count of files with no valid date
-	unique     int // Count of unique files processed
+	noData     int   // Count of files with no valid date
+	unique     int   // Count of unique files processed
+	skipped    int   // Count of files already organized by a previous run
+	library    int   // Count of files found in the existing destination library
+	dryRun     bool  // True when decisions are only recorded into a plan
+	planned    int64 // Bytes a dry run would write to the destination
+	sizeUnique int   // Count of files whose size no other file shares
+	headUnique int   // Count of files whose first and last bytes no other file of the same size shares
+	fullHashed int   // Count of files that needed a full checksum
+	paused     bool
+	resumed    chan struct{}  // Closed when a paused run is resumed
+	rawFormats map[string]int // Count of camera RAW files whose date was read, by format
 }
 
 // Options struct for configurable operations in the ProcessFiles() function.
 type Options struct {
-	MoveFiles bool // If true, files will be moved instead of copied
+	MoveFiles     bool         // If true, files will be moved instead of copied
+	Plan          *Plan        // If set, decisions are recorded into the plan and no files are touched
+	Journal       *Journal     // If set, every copy and move is recorded so the run can be undone
+	Index         *Index       // If set, files organized by previous runs are skipped and new ones are recorded
+	Library       *Library     // If set, new files are also deduplicated against the existing destination library
+	Deterministic bool         // If true, every file is inspected before anything is written, so repeated runs make the same decisions
+	Keeper        KeeperPolicy // Decides which of several identical files is organized; with rules, better files replace earlier originals
+	Hasher        Hasher       // The checksum algorithm; Index and Library must use the same one
+	Paranoid      bool         // If true, duplicates are compared with their original byte for byte
+	Preserve      Preserve     // Metadata kept by copies besides permissions; the zero value keeps none
+	Duplicates    LinkMode     // How duplicates are placed: copied, linked to their original or skipped
+	Originals     LinkMode     // How originals are placed unless moved: copied, hardlinked or cloned
+	MediaInfo     bool         // If true, videos the built-in parsers cannot date are passed to the mediainfo binary
 }
 
 // NewState initializes and returns a new State.
@@ -81,6 +105,65 @@ func (s *State) GetUniqueFileCount() int {
 	return s.unique
 }
 
+func (s *State) GetSkippedCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.skipped
+}
+
+func (s *State) GetLibraryCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.library
+}
+
+func (s *State) IsDryRun() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dryRun
+}
+
+func (s *State) GetPlannedBytes() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.planned
+}
+
+func (s *State) GetSizeUniqueCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sizeUnique
+}
+
+func (s *State) GetHeadUniqueCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.headUnique
+}
+
+func (s *State) GetFullHashCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.fullHashed
+}
+
+// GetRawFormatCounts returns how many camera RAW files of each format had their date read.
+func (s *State) GetRawFormatCounts() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := make(map[string]int, len(s.rawFormats))
+	for format, count := range s.rawFormats {
+		counts[format] = count
+	}
+	return counts
+}
+
+func (s *State) IsPaused() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.paused
+}
+
 func (s *State) GetMessage() string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -122,6 +205,125 @@ func (s *State) IncrementUnique() {
 	s.unique++
 }
 
+// IncrementSkipped safely increments the count of files skipped because a previous run organized them.
+func (s *State) IncrementSkipped() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped++
+}
+
+// IncrementLibrary safely increments the count of files found in the destination library.
+func (s *State) IncrementLibrary() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.library++
+}
+
+// AddPlannedBytes safely adds to the number of bytes a dry run would write.
+func (s *State) AddPlannedBytes(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.planned += n
+}
+
+// countStage safely increments the count of files the prefilter settled at the given stage.
+func (s *State) countStage(stage hashStage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch stage {
+	case stageSize:
+		s.sizeUnique++
+	case stagePartial:
+		s.headUnique++
+	default:
+		s.fullHashed++
+	}
+}
+
+// countRawFile safely counts an inspected camera RAW file by its format if its date was read.
+func (s *State) countRawFile(entry PlanEntry) {
+	format := rawFormat(filepath.Ext(entry.Source))
+	if format == "" || entry.Action != ActionOrganize {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rawFormats == nil {
+		s.rawFormats = make(map[string]int)
+	}
+	s.rawFormats[format]++
+}
+
+// SetDryRun marks the state as belonging to a dry run.
+func (s *State) SetDryRun(dryRun bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dryRun = dryRun
+}
+
+// Pause suspends the workers between files. Files already being processed are finished.
+func (s *State) Pause() {
+	s.mu.Lock()
+	if s.paused {
+		s.mu.Unlock()
+		return
+	}
+	s.paused = true
+	s.resumed = make(chan struct{})
+	s.mu.Unlock()
+
+	s.UpdateMessage("Paused")
+}
+
+// Resume lets paused workers continue with the next file.
+func (s *State) Resume() {
+	s.mu.Lock()
+	if !s.paused {
+		s.mu.Unlock()
+		return
+	}
+	s.paused = false
+	close(s.resumed)
+	s.mu.Unlock()
+
+	s.UpdateMessage("Resuming ...")
+}
+
+// waitIfPaused blocks while the state is paused. It returns ctx.Err() if ctx is cancelled first.
+func (s *State) waitIfPaused(ctx context.Context) error {
+	s.mu.RLock()
+	paused, resumed := s.paused, s.resumed
+	s.mu.RUnlock()
+
+	if !paused {
+		return ctx.Err()
+	}
+	select {
+	case <-resumed:
+		return ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// replaceOriginal safely moves an original with action previous to the duplicate count and
+// counts its replacement, an original with action next.
+func (s *State) replaceOriginal(previous, next Action) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if previous == ActionNoData {
+		s.noData--
+	} else {
+		s.unique--
+	}
+	if next == ActionNoData {
+		s.noData++
+	} else {
+		s.unique++
+	}
+	s.duplicates++
+}
+
 // UpdateMessage updates the current message.
 func (s *State) UpdateMessage(message string) {
 	s.mu.Lock()
@@ -130,12 +332,27 @@ func (s *State) UpdateMessage(message string) {
 }
 
 // Status returns a snapshot of the current state as a copy.
-//
-//goland:noinspection GoVetCopyLock
 func (s *State) Status() State {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return *s // Copy the state for immutability
+	// Copy the fields individually so the mutex is never copied
+	return State{
+		processed:  s.processed,
+		total:      s.total,
+		message:    s.message,
+		duplicates: s.duplicates,
+		errorCount: s.errorCount,
+		noData:     s.noData,
+		unique:     s.unique,
+		skipped:    s.skipped,
+		library:    s.library,
+		dryRun:     s.dryRun,
+		planned:    s.planned,
+		sizeUnique: s.sizeUnique,
+		headUnique: s.headUnique,
+		fullHashed: s.fullHashed,
+		paused:     s.paused,
+	}
 }
 
 // CountFiles calculates the total number of files in the given directory.
@@ -172,35 +389,124 @@ type Messenger interface {
 type ProgressTickMsg struct{}
 
 // ProcessFiles organizes files into a year/month/day directory tree, counting progress and updates state.
-func ProcessFiles(srcDir, destDir, logFilePath string, state *State, messenger Messenger, options Options) error {
+// When ctx is cancelled the directory walk stops, files already handed to workers are finished so
+// that no half-written file is left behind, and ctx.Err() is returned once all workers have drained.
+func ProcessFiles(ctx context.Context, srcDir, destDir, logFilePath string, state *State, messenger Messenger, options Options) error {
 	// Constants for special directories
 	duplicatesDir := filepath.Join(destDir, "duplicates")
 	noDataDir := filepath.Join(destDir, "nodata")
 
+	dryRun := options.Plan != nil
+	state.SetDryRun(dryRun)
+
+	// Checksums of different algorithms never match, so mixing them would miss every duplicate
+	if options.Index != nil && !options.Index.Hasher().matches(options.Hasher) {
+		return fmt.Errorf("index uses %s checksums, but the run uses %s", options.Index.Hasher(), options.Hasher)
+	}
+	if options.Library != nil && !options.Library.Hasher().matches(options.Hasher) {
+		return fmt.Errorf("library was indexed with %s checksums, but the run uses %s", options.Library.Hasher(), options.Hasher)
+	}
+	// Symbolic links would point at the old path of an original replaced during the run
+	if options.Duplicates == LinkSymlink && len(options.Keeper.Rules) > 0 && !options.Deterministic && !dryRun {
+		return fmt.Errorf("symbolic links to duplicates require a deterministic run when keeper rules are set")
+	}
+	if dryRun {
+		options.Plan.Hasher = options.Hasher.resolved()
+		options.Plan.Duplicates = options.Duplicates
+		options.Plan.Originals = options.Originals
+	}
+
 	state.UpdateMessage(fmt.Sprintf("Preparing to Process %d Files", state.GetTotalCount()))
 	messenger.Send(ProgressTickMsg{})
 
-	// Ensure required directories exist
-	if err := os.MkdirAll(duplicatesDir, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create duplicates directory: %w", err)
+	// A dry run only records decisions, so nothing is created and no log is written
+	var logFile io.Writer = io.Discard
+	if !dryRun {
+		// Ensure required directories exist
+		if err := os.MkdirAll(duplicatesDir, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create duplicates directory: %w", err)
+		}
+		if err := os.MkdirAll(noDataDir, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create no-data directory: %w", err)
+		}
+
+		// Open the log file for duplicates
+		file, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		defer file.Close()
+		logFile = file
+
+		// Copies of a run that crashed never made it into place
+		if removed, err := removeTempFiles(destDir); err != nil {
+			return err
+		} else if removed > 0 {
+			_, _ = fmt.Fprintf(logFile, "Removed %d temporary file(s) left by an interrupted run\n", removed)
+		}
 	}
-	if err := os.MkdirAll(noDataDir, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create no-data directory: %w", err)
+
+	// Content organized by previous runs counts as already seen
+	registry := newRegistry(options.Index.Originals(), options.Library, options.Plan)
+
+	// Sizes are collected first, so that files which cannot have a duplicate are known up front
+	files, err := collectFiles(ctx, srcDir)
+	switch {
+	case err != nil:
+	case options.Deterministic:
+		err = processDeterministic(ctx, files, destDir, duplicatesDir, noDataDir, registry, logFile, state, messenger, options)
+	default:
+		// Copied files that cannot have a duplicate are hashed on the way to the destination.
+		// Moved and linked files and plans need the checksum before anything is written.
+		filter := newPrefilter(files, options.Library, options.Index)
+		lazy := !dryRun && !options.MoveFiles && options.Originals.copies()
+		if lazy {
+			state.UpdateMessage("Comparing files of the same size ...")
+			err = filter.hashPartials(ctx, files, options.Hasher, state)
+		}
+
+		if err == nil {
+			err = forEach(ctx, files, state, func(file foundFile) {
+				stage := stageFull
+				if lazy {
+					stage = filter.stage(file)
+				}
+				state.countStage(stage)
+				if err := processFile(file.path, stage == stageFull, destDir, duplicatesDir, noDataDir, registry, logFile, state, options); err != nil {
+					countError(err, logFile, state)
+				}
+				// A file has been "processed" (attempted), so increment the counter
+				// to ensure the progress bar completes.
+				state.IncrementProcessed()
+				if !state.IsPaused() {
+					state.UpdateMessage("Processing ...")
+				}
+				// Notify the TUI that an update is available.
+				messenger.Send(ProgressTickMsg{})
+			})
+		}
 	}
 
-	// Open the log file for duplicates
-	logFile, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open log file: %w", err)
+	switch {
+	case ctx.Err() != nil:
+		state.UpdateMessage("Cancelled")
+	case dryRun:
+		state.UpdateMessage("Planning Complete")
+	default:
+		state.UpdateMessage("Processing Complete")
 	}
-	defer logFile.Close()
+	messenger.Send(ProgressTickMsg{})
 
-	// Shared resources
-	duplicates := make(map[string][]string) // checksum -> file paths
-	var mapLock sync.Mutex                  // Protects access to `duplicates`
+	// The calling function (`main`) is responsible for quitting the TUI program.
+	return err
+}
 
-	// Channel for distributing files to workers
-	filePathChan := make(chan string)
+// forEach hands every item, such as a file or a plan entry, to handle from a pool of workers.
+// Workers wait while the state is paused. Cancelling ctx stops handing out items; items already
+// handed to handle are finished before forEach returns ctx.Err().
+func forEach[T any](ctx context.Context, items []T, state *State, handle func(item T)) error {
+	// Channel for distributing items to workers
+	itemChan := make(chan T)
 
 	// WaitGroup to synchronize workers
 	var wg sync.WaitGroup
@@ -211,151 +517,403 @@ func ProcessFiles(srcDir, destDir, logFilePath string, state *State, messenger M
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for path := range filePathChan {
-				if err := processFile(path, destDir, duplicatesDir, noDataDir, duplicates, &mapLock, logFile, state, options); err != nil {
-					state.IncrementError()
+			for item := range itemChan {
+				// Hold on to the item while paused; once cancelled, only drain the channel
+				if err := state.waitIfPaused(ctx); err != nil {
+					continue
 				}
-				// A file has been "processed" (attempted), so increment the counter
-				// to ensure the progress bar completes.
-				state.IncrementProcessed()
-				state.UpdateMessage("Processing ...")
-				// Notify the TUI that an update is available.
-				messenger.Send(ProgressTickMsg{})
+				handle(item)
 			}
 		}()
 	}
 
-	// Walk the source directory and send file paths to the channel
-	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+send:
+	for _, item := range items {
+		select {
+		case itemChan <- item: // Send the item to workers
+		case <-ctx.Done():
+			break send // Stop handing out items, in-flight items are still finished
 		}
-		if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") { // Ignore directories and hidden files
-			filePathChan <- path // Send the file to workers
-		}
-		return nil
-	})
-
-	state.UpdateMessage("Processing Complete")
-	messenger.Send(ProgressTickMsg{})
+	}
 
-	// Close the channel after walking the directory
-	close(filePathChan)
+	// Close the channel once every item has been handed out
+	close(itemChan)
 
 	// Wait for all workers to finish
 	wg.Wait()
+	return ctx.Err()
+}
 
-	// The calling function (`main`) is responsible for quitting the TUI program.
-	return err
+// countError counts a file that failed to process. Moves that left their source behind are
+// logged as well, since the file is then in both places.
+func countError(err error, logFile io.Writer, state *State) {
+	state.IncrementError()
+	if errors.Is(err, errSourceNotRemoved) {
+		_, _ = fmt.Fprintf(logFile, "Source not removed: %v\n", err)
+	}
 }
 
 // processFile handles the processing of a single file, including duplicate detection and organizing into a directory structure.
 func processFile(
 	path string,
+	hash bool,
 	destDir string,
 	duplicatesDir string,
 	noDataDir string,
-	duplicates map[string][]string,
-	mapLock *sync.Mutex,
-	logFile *os.File,
+	registry *registry,
+	logFile io.Writer,
 	state *State,
 	options Options,
 ) error {
-	// Open the file to calculate checksum and extract metadata
-	file, err := os.Open(path)
+	// Files that are not hashed up front are copied from the same pass over the source
+	source, err := openSource(path, options.Hasher, !hash)
 	if err != nil {
 		return fmt.Errorf("failed to open file %s: %w", path, err)
 	}
-	defer file.Close()
+	defer source.Close()
 
-	// Extract the file extension
-	extension := strings.ToLower(filepath.Ext(path))
+	entry, err := inspectSource(source, hash, destDir, noDataDir, options.Index, options.MediaInfo)
+	if errors.Is(err, errAlreadyOrganized) {
+		state.IncrementSkipped()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state.countRawFile(entry)
+	if !hash {
+		return placeUnhashed(entry, source, registry, state, options)
+	}
+	// Files are copied and moved by name below, and open files cannot be moved on every system
+	source.Close()
+	checksum := entry.Checksum
+
+	// With a keeper policy a better file can take the place of an original organized earlier
+	var keeper *KeeperPolicy
+	if len(options.Keeper.Rules) > 0 {
+		keeper = &options.Keeper
+	}
 
-	// Extract the creation date based on the file type
-	var date time.Time
-	if isVideoFile(extension) {
-		// Handle video files
-		date, err = getVideoCreationDate(path)
-		if err != nil {
-			date = time.Time{} // No valid date found
+	// Only the claim on the checksum is made under lock; the file operations below run in parallel
+	destPath, original, outcome := registry.claim(entry, keeper)
+	for outcome == claimDuplicate && (options.Paranoid || (options.Plan == nil && options.Duplicates.links())) {
+		// The original may still be being written, and only a placed one can be compared or linked to
+		placed, ok := registry.placed(checksum)
+		if ok {
+			original = placed
+			break
+		}
+		// The original could not be placed, so this file may become the original instead
+		destPath, original, outcome = registry.claim(entry, keeper)
+	}
+
+	switch outcome {
+	case claimDuplicate:
+		if options.Paranoid && !verifyDuplicate(entry, original, options) {
+			entry.Destination = registry.reserve(entry.Destination)
+			return placeCollision(entry, original.Destination, logFile, state, options)
+		}
+		duplicatePath := registry.reserve(filepath.Join(duplicatesDir, filepath.Base(path)))
+		return placeDuplicate(entry, duplicatePath, original.Destination, logFile, state, options)
+	case claimReplace:
+		entry.Destination = destPath
+		if options.Paranoid && !verifyDuplicate(entry, original, options) {
+			registry.settle(checksum) // Keep the previous original
+			return placeCollision(entry, original.Destination, logFile, state, options)
 		}
+		duplicatePath := registry.reserve(filepath.Join(duplicatesDir, filepath.Base(original.Source)))
+		return replaceOriginal(entry, original, duplicatePath, registry, logFile, state, options)
+	}
+
+	entry.Destination = destPath
+	if entry.Action == ActionNoData {
+		state.IncrementNoData() // A new file with no valid date
 	} else {
-		date = getPhotoCreationDate(file, date)
+		state.IncrementUnique() // Count files that are processed normally
 	}
 
-	// Reset the file pointer for reading the checksum
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return fmt.Errorf("failed to reset file pointer for %s: %w", path, err)
+	// Determine whether to record, move or copy the file based on options
+	if options.Plan != nil {
+		options.Plan.add(entry)
+		state.AddPlannedBytes(options.Plan.writtenBytes(entry))
+		registry.settle(checksum)
+		return nil
 	}
 
-	// Calculate the file checksum for duplicate detection
-	checksum, err := calculateChecksum(file)
-	if err != nil {
-		return fmt.Errorf("failed to calculate checksum for %s: %w", path, err)
+	placedPath, err := placeOriginal(entry, options)
+	if err != nil && !errors.Is(err, errSourceNotRemoved) {
+		// Let the next file with the same content become the original
+		registry.release(checksum)
+		return err
 	}
+	entry.Destination = placedPath
+	registry.settleAt(entry)
+	return recordOriginal(entry, err, options)
+}
 
-	// Lock the shared duplicates map for duplicate operations
-	mapLock.Lock()
-	defer mapLock.Unlock()
+// placeDuplicate places a duplicate into the duplicates directory, or records it in the plan.
+func placeDuplicate(entry PlanEntry, duplicatePath, originalPath string, logFile io.Writer, state *State, options Options) error {
+	entry.Action = ActionDuplicate
+	entry.Destination = duplicatePath
+	entry.DuplicateOf = originalPath
+
+	if options.Plan != nil {
+		options.Plan.add(entry)
+		state.AddPlannedBytes(options.Plan.writtenBytes(entry))
+	} else if err := writeDuplicate(entry, options); err != nil {
+		return err
+	}
+	state.IncrementDuplicates()
+	_, _ = fmt.Fprintf(logFile, "Duplicate detected: %s (duplicate of: %s)\n", entry.Source, originalPath)
+	return nil
+}
 
-	if paths, exists := duplicates[checksum]; exists {
-		// Duplicate file logic
-		duplicatePath := filepath.Join(duplicatesDir, filepath.Base(path))
-		if _, err := os.Stat(duplicatePath); err == nil {
-			duplicatePath = resolveNamingConflict(duplicatePath)
+// writeDuplicate copies or links a duplicate to its Destination as selected by
+// options.Duplicates, journals it and adds it to the index. A skipped duplicate is only
+// indexed, with the path of its original, so that later runs skip it as well.
+func writeDuplicate(entry PlanEntry, options Options) error {
+	record := IndexRecord{
+		Checksum:  entry.Checksum,
+		Path:      entry.Destination,
+		Source:    entry.Source,
+		Size:      entry.Size,
+		ModTime:   entry.ModTime,
+		Duplicate: true,
+	}
+	if options.Duplicates == LinkSkip {
+		record.Path = entry.DuplicateOf
+		return options.Index.add(record)
+	}
+
+	var operation string
+	placedPath, err := resolveNamingConflict(entry.Destination, func(dest string) error {
+		var err error
+		operation, err = placeDuplicateFile(entry.Source, dest, entry.DuplicateOf, options.Duplicates, options.Preserve)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy duplicate file %s: %w", entry.Source, err)
+	}
+	entry.Destination = placedPath
+	record.Path = placedPath
+	if err := options.Journal.record(operation, entry.Source, entry.Destination, entry.Checksum); err != nil {
+		return err
+	}
+	return options.Index.add(record)
+}
+
+// replaceOriginal organizes entry in place of an original that the keeper policy likes less.
+// The new original is placed first; the previous one is then moved from its organized path
+// into the duplicates directory, as if entry had been seen first. It is moved whatever
+// options.Duplicates says, since it may be the only copy of its source. The caller must hold the
+// replacing claim, which is handed back to the registry.
+func replaceOriginal(entry, original PlanEntry, duplicatePath string, registry *registry, logFile io.Writer, state *State, options Options) error {
+	var placeErr error // Set if entry was copied but its source could not be removed
+	if options.Plan != nil {
+		options.Plan.add(entry)
+		demoted := options.Plan.demote(original.Source, duplicatePath, entry.Destination)
+		state.AddPlannedBytes(options.Plan.writtenBytes(entry) + demoted)
+	} else {
+		var placedPath string
+		placedPath, placeErr = placeOriginal(entry, options)
+		if placeErr != nil && !errors.Is(placeErr, errSourceNotRemoved) {
+			registry.settle(entry.Checksum) // Keep the previous original
+			return placeErr
 		}
-		if err := copyFile(path, duplicatePath); err != nil {
-			return fmt.Errorf("failed to copy duplicate file %s: %w", path, err)
+		entry.Destination = placedPath
+		if err := recordOriginal(entry, placeErr, options); err != nil && !errors.Is(err, errSourceNotRemoved) {
+			registry.replace(entry)
+			return err
+		}
+		var err error
+		duplicatePath, err = resolveNamingConflict(duplicatePath, func(dest string) error {
+			return moveFile(original.Destination, dest, entry.Checksum, options.Hasher, options.Preserve)
+		})
+		if errors.Is(err, errSourceNotRemoved) {
+			os.Remove(duplicatePath) // The replaced original is still in place
+		}
+		if err != nil {
+			registry.replace(entry)
+			return fmt.Errorf("failed to move replaced original %s: %w", original.Destination, err)
+		}
+		if err := options.Journal.record(OperationMove, original.Destination, duplicatePath, entry.Checksum); err != nil {
+			registry.replace(entry)
+			return err
+		}
+		if err := options.Index.add(IndexRecord{
+			Checksum:  entry.Checksum,
+			Path:      duplicatePath,
+			Source:    original.Source,
+			Size:      original.Size,
+			ModTime:   original.ModTime,
+			Duplicate: true,
+		}); err != nil {
+			registry.replace(entry)
+			return err
 		}
-		duplicates[checksum] = append(paths, path)
-		state.IncrementDuplicates()
-		_, _ = logFile.WriteString(fmt.Sprintf("Duplicate detected: %s (duplicate of: %s)\n", path, paths[0]))
-		return nil
 	}
+	registry.replace(entry)
 
-	// Determine the destination path
-	var destPath string
-	if date.IsZero() {
-		// No valid date: copy to the no-data directory
-		state.IncrementNoData() // A new file with no valid date
-		destPath = filepath.Join(noDataDir, filepath.Base(path))
-		if _, err := os.Stat(destPath); err == nil {
-			destPath = resolveNamingConflict(destPath)
+	state.replaceOriginal(original.Action, entry.Action)
+	_, _ = fmt.Fprintf(logFile, "Duplicate detected: %s (duplicate of: %s, replaced by keeper policy)\n", original.Source, entry.Destination)
+	return placeErr
+}
+
+// recordOriginal journals an original placed by placeOriginal and adds it to the index.
+// placeErr is the error placeOriginal returned with it: a move whose source could not be
+// removed is journaled as the copy it became, and placeErr is returned once it is recorded.
+func recordOriginal(entry PlanEntry, placeErr error, options Options) error {
+	operation := OperationCopy
+	if options.MoveFiles && placeErr == nil {
+		operation = OperationMove
+	}
+	if err := options.Journal.record(operation, entry.Source, entry.Destination, entry.Checksum); err != nil {
+		return err
+	}
+	if err := options.Index.add(IndexRecord{
+		Checksum:  entry.Checksum,
+		Path:      entry.Destination,
+		Source:    entry.Source,
+		Size:      entry.Size,
+		ModTime:   entry.ModTime,
+		Collision: entry.CollidesWith != "",
+	}); err != nil {
+		return err
+	}
+	return placeErr
+}
+
+// errAlreadyOrganized is returned by inspectFile for files that a previous run already organized.
+var errAlreadyOrganized = errors.New("already organized")
+
+// inspectFile extracts the creation date and checksum of a file and works out where it would be
+// organized if it turns out to be the original. The destination is not reserved yet. If hash
+// is false the checksum is left empty and the destination name does not embed it. If mediaInfo is
+// set, videos the built-in parsers cannot date are passed to the mediainfo binary.
+func inspectFile(path string, hash bool, destDir, noDataDir string, index *Index, hasher Hasher, mediaInfo bool) (PlanEntry, error) {
+	// Open the file to calculate checksum and extract metadata
+	source, err := openSource(path, hasher, false)
+	if err != nil {
+		return PlanEntry{}, fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer source.Close()
+	return inspectSource(source, hash, destDir, noDataDir, index, mediaInfo)
+}
+
+// inspectSource does the work of inspectFile on an open source. The date and the checksum are
+// taken in a single pass over the file.
+func inspectSource(source *sourceFile, hash bool, destDir, noDataDir string, index *Index, mediaInfo bool) (PlanEntry, error) {
+	path := source.file.Name()
+
+	// Gather the size and modification time for the plan and the index
+	info, err := source.file.Stat()
+	if err != nil {
+		return PlanEntry{}, fmt.Errorf("failed to stat file %s: %w", path, err)
+	}
+
+	// Skip files that a previous run already organized
+	if index.done(path, info) {
+		return PlanEntry{}, errAlreadyOrganized
+	}
+
+	// Extract the file extension
+	extension := strings.ToLower(filepath.Ext(path))
+
+	// Extract the creation date based on the file type
+	var date time.Time
+	dateSource := DateSourceExif
+	if isVideoFile(extension) {
+		// Handle video files; only their metadata boxes are read, at their offsets
+		date, err = getVideoCreationDate(source.file, mediaInfo)
+		if err != nil {
+			date = time.Time{} // No valid date found
 		}
+		dateSource = DateSourceVideo
+	} else if format := rawFormat(extension); format != "" {
+		// Camera RAW files keep their EXIF data where their format puts it
+		date = source.rawDate(format, info.Size())
+	} else if isHEIFFile(extension) {
+		// The EXIF data of HEIF images is an item somewhere in the file, read at its offset
+		date = getHEIFCreationDate(source.file, info.Size())
+	} else if isChunkedImageFile(extension) {
+		// PNG, WebP and GIF images keep their metadata in chunks, read at their offsets
+		date, dateSource = getChunkedImageCreationDate(source.file, info.Size(), extension)
 	} else {
+		date = source.photoDate()
+	}
+
+	// Calculate the file checksum for duplicate detection
+	checksum := ""
+	if hash {
+		// The bytes read for the date have been hashed already
+		checksum, err = source.checksum()
+		if err != nil {
+			return PlanEntry{}, fmt.Errorf("failed to calculate checksum for %s: %w", path, err)
+		}
+	}
+
+	entry := PlanEntry{
+		Source:      path,
+		Action:      ActionNoData,
+		Destination: filepath.Join(noDataDir, filepath.Base(path)),
+		Checksum:    checksum,
+		DateSource:  DateSourceNone,
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+	}
+	if !date.IsZero() {
 		// Valid date: organize into YYYY/MM/DD directory structure
+		entry.Action = ActionOrganize
+		entry.DateSource = dateSource
 		destFolder := filepath.Join(destDir, date.Format("2006"), date.Format("01"), date.Format("02"))
-		if err := os.MkdirAll(destFolder, os.ModePerm); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", destFolder, err)
-		}
-		destFileName := fmt.Sprintf("%s_%s%s", strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), checksum[:8], filepath.Ext(path))
-		destPath = filepath.Join(destFolder, destFileName)
-		if _, err := os.Stat(destPath); err == nil {
-			destPath = resolveNamingConflict(destPath)
+		entry.Destination = filepath.Join(destFolder, filepath.Base(path))
+		if hash {
+			entry.Destination = organizedPath(destFolder, path, checksum)
 		}
-		state.IncrementUnique() // Count files that are processed normally
 	}
+	return entry, nil
+}
+
+// organizedPath returns the path of a file organized into destFolder. The checksum prefix in
+// the name keeps different files with the same name apart and lets later runs recognize it.
+func organizedPath(destFolder, source, checksum string) string {
+	name := fmt.Sprintf("%s_%s%s", strings.TrimSuffix(filepath.Base(source), filepath.Ext(source)), checksum[:8], filepath.Ext(source))
+	return filepath.Join(destFolder, name)
+}
 
-	// Determine whether to move or copy the file based on options
+// placeOriginal moves an original into its reserved destination, or places it there as
+// selected by options.Originals. If another process took the name in the meantime, the next free
+// numbered name is used instead; the path the original was placed at is returned. A moved file
+// whose source could not be removed is in place as a copy; the error then matches
+// errSourceNotRemoved and is to be passed to recordOriginal.
+func placeOriginal(entry PlanEntry, options Options) (string, error) {
+	destFolder := filepath.Dir(entry.Destination)
+	if err := os.MkdirAll(destFolder, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %w", destFolder, err)
+	}
 	if options.MoveFiles {
-		if err := moveFile(path, destPath); err != nil {
-			return fmt.Errorf("failed to move file %s: %w", path, err)
-		}
-	} else {
-		if err := copyFile(path, destPath); err != nil {
-			return fmt.Errorf("failed to copy file %s: %w", path, err)
+		path, err := resolveNamingConflict(entry.Destination, func(dest string) error {
+			return moveFile(entry.Source, dest, entry.Checksum, options.Hasher, options.Preserve)
+		})
+		if err != nil {
+			return path, fmt.Errorf("failed to move file %s: %w", entry.Source, err)
 		}
+		return path, nil
 	}
-
-	// Update duplicates map
-	duplicates[checksum] = []string{destPath}
-	return nil
+	path, err := resolveNamingConflict(entry.Destination, func(dest string) error {
+		return placeOriginalFile(entry.Source, dest, options.Originals, options.Preserve)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to copy file %s: %w", entry.Source, err)
+	}
+	return path, nil
 }
 
-func getPhotoCreationDate(file *os.File, date time.Time) time.Time {
-	// Handle photo files
+func getPhotoCreationDate(file io.Reader, date time.Time) time.Time {
+	// Handle photo files. A sub-IFD that cannot be decoded, as in the maker notes of some RAW
+	// files, leaves the rest of the EXIF data usable.
 	x, err := exif.Decode(file)
-	if err == nil {
+	if err == nil || !exif.IsCriticalError(err) {
 		date, err = x.DateTime()
 		if err != nil {
 			date = time.Time{} // No valid EXIF date found
@@ -366,64 +924,116 @@ func getPhotoCreationDate(file *os.File, date time.Time) time.Time {
 	return date
 }
 
-// copyFile copies a file from the source path to the destination path.
-func copyFile(src, dest string) error {
+// isChunkedImageFile reports whether extension belongs to an image format that stores its
+// metadata in chunks of its own rather than in JPEG or TIFF structures.
+func isChunkedImageFile(extension string) bool {
+	switch strings.ToLower(extension) {
+	case ".png", ".webp", ".gif":
+		return true
+	}
+	return false
+}
+
+// getChunkedImageCreationDate extracts the creation date of a PNG, WebP or GIF image of the
+// given size with the parser for its format, along with the source of the date.
+func getChunkedImageCreationDate(r io.ReaderAt, size int64, extension string) (time.Time, string) {
+	switch strings.ToLower(extension) {
+	case ".png":
+		return getPNGCreationDate(r, size)
+	case ".webp":
+		return getWebPCreationDate(r, size)
+	case ".gif":
+		return getGIFCreationDate(r, size), DateSourceXMP
+	}
+	return time.Time{}, DateSourceNone
+}
+
+// copyFile copies a file from the source path to the destination path, keeping its permissions
+// and the metadata selected by preserve. The copy is written to a temporary file that is
+// renamed into place once complete, so an interrupted copy never leaves a truncated file at dest.
+// An existing dest is never replaced; the error then matches fs.ErrExist.
+func copyFile(src, dest string, preserve Preserve) error {
 	sourceFile, err := os.Open(src)
 	if err != nil {
 		return fmt.Errorf("failed to open source file: %w", err)
 	}
 	defer sourceFile.Close()
 
-	destFile, err := os.Create(dest)
+	sourceInfo, err := sourceFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get source file info: %w", err)
+	}
+
+	tempFile, err := createTempFile(filepath.Dir(dest))
 	if err != nil {
 		return fmt.Errorf("failed to create destination file: %w", err)
 	}
-	defer destFile.Close()
 
-	// Perform the file copy
-	if _, err := io.Copy(destFile, sourceFile); err != nil {
+	// Perform the file copy, with the same permissions as the source. Between two *os.File
+	// values io.Copy has the kernel copy the content (copy_file_range on Linux), so the source,
+	// usually just hashed, is not read into memory again.
+	if _, err := io.Copy(tempFile, sourceFile); err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
 		return fmt.Errorf("failed to copy file contents: %w", err)
 	}
-
-	// Ensure the destination file has the same permissions as the source
-	sourceInfo, err := os.Stat(src)
-	if err != nil {
-		return fmt.Errorf("failed to get source file info: %w", err)
+	if err := finishTempFile(tempFile, sourceInfo.Mode()); err != nil {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("failed to write destination file: %w", err)
 	}
-	if err := os.Chmod(dest, sourceInfo.Mode()); err != nil {
-		return fmt.Errorf("failed to set file permissions on destination: %w", err)
+	if err := preserveMetadata(src, sourceInfo, tempFile.Name(), preserve); err != nil {
+		os.Remove(tempFile.Name())
+		return err
 	}
 
+	if err := renameTempFile(tempFile.Name(), dest); err != nil {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("failed to rename destination file: %w", err)
+	}
 	return nil
 }
 
-// calculateChecksum computes an MD5 checksum for a file.
-func calculateChecksum(file *os.File) (string, error) {
-	hasher := md5.New()
-	if _, err := io.Copy(hasher, file); err != nil {
+// calculateChecksum computes the checksum of a file with the given algorithm.
+func calculateChecksum(file *os.File, hasher Hasher) (string, error) {
+	hash := hasher.New()
+	if _, err := io.Copy(hash, file); err != nil {
 		return "", err
 	}
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-// resolveNamingConflict generates a unique filename to resolve collisions.
-func resolveNamingConflict(path string) string {
-	dir, file := filepath.Split(path)
-	ext := filepath.Ext(file)
-	base := strings.TrimSuffix(file, ext)
-
-	// Add numeric suffix until the name is unique
+// resolveNamingConflict creates a file at path with create, or else at the first numbered name
+// after it (photo_1.jpg, photo_2.jpg, ...) that is free, and returns the path it used. create
+// must fail with an error matching fs.ErrExist rather than replace an existing file; the name is
+// then taken by the time create returns, so concurrent workers and runs never share one.
+func resolveNamingConflict(path string, create func(string) error) (string, error) {
+	candidate := path
 	for i := 1; ; i++ {
-		newPath := filepath.Join(dir, fmt.Sprintf("%s_%d%s", base, i, ext))
-		if _, err := os.Stat(newPath); os.IsNotExist(err) {
-			return newPath // Found a unique name
+		err := create(candidate)
+		if !errors.Is(err, fs.ErrExist) {
+			return candidate, err
 		}
+		candidate = numberedPath(path, i)
 	}
 }
 
-// moveFile moves a file from source to destination, replacing copying and removing logic for simplicity.
-func moveFile(src, dest string) error {
-	if err := os.Rename(src, dest); err != nil {
+// numberedPath returns path with _n appended to the name before its extension.
+func numberedPath(path string, n int) string {
+	dir, file := filepath.Split(path)
+	ext := filepath.Ext(file)
+	return filepath.Join(dir, fmt.Sprintf("%s_%d%s", strings.TrimSuffix(file, ext), n, ext))
+}
+
+// moveFile moves a file from source to destination. Between file systems, where a rename is
+// impossible, the file is copied with the metadata selected by preserve and the source is only
+// removed once the copy has been verified against checksum, calculated with hasher. An existing
+// dest is never replaced; the error then matches fs.ErrExist.
+func moveFile(src, dest, checksum string, hasher Hasher, preserve Preserve) error {
+	err := renameNoReplace(src, dest)
+	if err != nil && isCrossDevice(err) {
+		err = moveAcrossDevices(src, dest, checksum, hasher, preserve)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to move file from %s to %s: %w", src, dest, err)
 	}
 	return nil
@@ -431,7 +1041,7 @@ func moveFile(src, dest string) error {
 
 // Helper function to determine if a file is a video
 func isVideoFile(extension string) bool {
-	videoExtensions := []string{".mp4", ".avi", ".mov", ".mkv", ".wmv"} // Add more extensions as needed
+	videoExtensions := []string{".mp4", ".avi", ".mov", ".mkv", ".wmv", ".3gp"} // Add more extensions as needed
 	for _, ext := range videoExtensions {
 		if strings.EqualFold(extension, ext) {
 			return true
@@ -440,12 +1050,44 @@ func isVideoFile(extension string) bool {
 	return false
 }
 
-// Helper function to retrieve creation date for video files
-func getVideoCreationDate(filePath string) (time.Time, error) {
-	if !isVideoFile(filepath.Ext(filePath)) {
-		return time.Time{}, fmt.Errorf("not a valid video file: %s", filePath)
+// getVideoCreationDate extracts the creation date of a video from its container metadata. If
+// mediaInfo is set, videos that the built-in parsers cannot date are passed to the external
+// mediainfo binary instead. The file is only read with ReadAt, so its offset does not move.
+func getVideoCreationDate(file *os.File, mediaInfo bool) (time.Time, error) {
+	extension := filepath.Ext(file.Name())
+	if !isVideoFile(extension) {
+		return time.Time{}, fmt.Errorf("not a valid video file: %s", file.Name())
 	}
 
+	info, err := file.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	date, err := getContainerCreationDate(file, info.Size(), extension)
+	if err != nil && mediaInfo {
+		return getMediaInfoCreationDate(file.Name())
+	}
+	return date, err
+}
+
+// getContainerCreationDate reads the creation date of a video of the given size with the
+// built-in parser for its container format.
+func getContainerCreationDate(r io.ReaderAt, size int64, extension string) (time.Time, error) {
+	switch strings.ToLower(extension) {
+	case ".mp4", ".mov", ".3gp":
+		return getISOCreationDate(r, size)
+	case ".mkv":
+		return getMatroskaCreationDate(r, size)
+	case ".avi":
+		return getAVICreationDate(r, size)
+	case ".wmv":
+		return getASFCreationDate(r, size)
+	}
+	return time.Time{}, fmt.Errorf("no built-in parser for %s files", extension)
+}
+
+// getMediaInfoCreationDate retrieves the creation date of a video file with the mediainfo binary.
+func getMediaInfoCreationDate(filePath string) (time.Time, error) {
 	info, err := yami.GetMediaInfo(filePath, 10*time.Second, "--Language=raw")
 	if err != nil {
 		return time.Time{}, fmt.Errorf("failed to retrieve video metadata: %w", err)
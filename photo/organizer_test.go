@@ -1,6 +1,9 @@
 package photo
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -192,6 +195,7 @@ func TestIsVideoFile(t *testing.T) {
 		{".MP4", true}, // Test case insensitivity
 		{".avi", true},
 		{".mov", true},
+		{".3gp", true},
 		{".mkv", true},
 		{".wmv", true},
 		{".jpg", false},
@@ -224,24 +228,38 @@ func TestResolveNamingConflict(t *testing.T) {
 		t.Fatalf("Failed to create test file: %v", err)
 	}
 
+	// Files are created exclusively, so a taken name is never overwritten
+	create := func(path string) error {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}
+
 	// Test resolving naming conflict
-	resolvedPath := resolveNamingConflict(testFile)
+	resolvedPath, err := resolveNamingConflict(testFile, create)
 	expectedPath := filepath.Join(tempDir, "test_1.txt")
 
-	if resolvedPath != expectedPath {
-		t.Errorf("Expected resolved path to be %s, got %s", expectedPath, resolvedPath)
+	if err != nil || resolvedPath != expectedPath {
+		t.Errorf("Expected resolved path to be %s, got %s, %v", expectedPath, resolvedPath, err)
 	}
-
-	// Create the first resolved file and test again
-	if err := os.WriteFile(expectedPath, []byte("test"), 0644); err != nil {
-		t.Fatalf("Failed to create resolved test file: %v", err)
+	if content, _ := os.ReadFile(testFile); string(content) != "test" {
+		t.Errorf("Expected the existing file to be kept, got %q", content)
 	}
 
-	resolvedPath = resolveNamingConflict(testFile)
+	// The first resolved file was created by the first call
+	resolvedPath, err = resolveNamingConflict(testFile, create)
 	expectedPath = filepath.Join(tempDir, "test_2.txt")
 
-	if resolvedPath != expectedPath {
-		t.Errorf("Expected second resolved path to be %s, got %s", expectedPath, resolvedPath)
+	if err != nil || resolvedPath != expectedPath {
+		t.Errorf("Expected second resolved path to be %s, got %s, %v", expectedPath, resolvedPath, err)
+	}
+
+	// Errors other than a taken name end the search
+	missing := filepath.Join(tempDir, "missing", "test.txt")
+	if _, err := resolveNamingConflict(missing, create); !os.IsNotExist(err) {
+		t.Errorf("Expected a not-exist error, got %v", err)
 	}
 }
 
@@ -267,7 +285,7 @@ func TestCalculateChecksum(t *testing.T) {
 	}
 
 	// Calculate checksum
-	checksum, err := calculateChecksum(tempFile)
+	checksum, err := calculateChecksum(tempFile, HashMD5)
 	if err != nil {
 		t.Fatalf("calculateChecksum returned an error: %v", err)
 	}
@@ -300,7 +318,7 @@ func TestCopyFile(t *testing.T) {
 	destPath := filepath.Join(tempDir, "destination.txt")
 
 	// Test copying the file
-	if err := copyFile(srcPath, destPath); err != nil {
+	if err := copyFile(srcPath, destPath, Preserve{}); err != nil {
 		t.Fatalf("copyFile returned an error: %v", err)
 	}
 
@@ -331,7 +349,7 @@ func TestCopyFile(t *testing.T) {
 
 	// Test error handling: non-existent source file
 	nonExistentSrc := filepath.Join(tempDir, "nonexistent.txt")
-	err = copyFile(nonExistentSrc, destPath)
+	err = copyFile(nonExistentSrc, destPath, Preserve{})
 	if err == nil {
 		t.Errorf("Expected error for non-existent source file, got nil")
 	}
@@ -339,7 +357,7 @@ func TestCopyFile(t *testing.T) {
 	// Test error handling: invalid destination path
 	invalidDestDir := filepath.Join(tempDir, "nonexistent-dir")
 	invalidDestPath := filepath.Join(invalidDestDir, "invalid.txt")
-	err = copyFile(srcPath, invalidDestPath)
+	err = copyFile(srcPath, invalidDestPath, Preserve{})
 	if err == nil {
 		t.Errorf("Expected error for invalid destination path, got nil")
 	}
@@ -365,7 +383,7 @@ func TestMoveFile(t *testing.T) {
 	destPath := filepath.Join(tempDir, "destination.txt")
 
 	// Test moving the file
-	if err := moveFile(srcPath, destPath); err != nil {
+	if err := moveFile(srcPath, destPath, "", HashMD5, DefaultPreserve); err != nil {
 		t.Fatalf("moveFile returned an error: %v", err)
 	}
 
@@ -386,7 +404,7 @@ func TestMoveFile(t *testing.T) {
 
 	// Test error handling: non-existent source file
 	nonExistentSrc := filepath.Join(tempDir, "nonexistent.txt")
-	err = moveFile(nonExistentSrc, destPath)
+	err = moveFile(nonExistentSrc, destPath, "", HashMD5, DefaultPreserve)
 	if err == nil {
 		t.Errorf("Expected error for non-existent source file, got nil")
 	}
@@ -402,7 +420,7 @@ func TestMoveFile(t *testing.T) {
 	}
 
 	// Try to move it to a non-existent directory
-	err = moveFile(newSrcPath, nonExistentDestPath)
+	err = moveFile(newSrcPath, nonExistentDestPath, "", HashMD5, DefaultPreserve)
 	if err == nil {
 		t.Errorf("Expected error when moving to non-existent directory, got nil")
 	}
@@ -446,33 +464,24 @@ func TestGetPhotoCreationDate(t *testing.T) {
 	}
 }
 
-// TestGetVideoCreationDate tests the getVideoCreationDate function
-// Note: This test is limited since it depends on the external yami package
+// TestGetVideoCreationDate tests the getVideoCreationDate function with an invalid video file
 func TestGetVideoCreationDate(t *testing.T) {
-	// Since we can't easily create a valid video file for testing,
-	// and the getVideoCreationDate function depends on an external package (yami),
-	// we'll skip this test in normal test runs.
-	//t.Skip("Skipping test that requires valid video files and external dependencies")
-
-	// The following code is kept for reference but is skipped during testing
 	// Create a temporary file that's not a valid video
 	tempFile, err := os.CreateTemp("", "test-video-*.mp4")
 	if err != nil {
 		t.Fatalf("Failed to create temp file: %v", err)
 	}
 	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
 
 	// Write some non-video data
 	if _, err := tempFile.WriteString("This is not a valid video file"); err != nil {
 		t.Fatalf("Failed to write to temp file: %v", err)
 	}
 
-	// Close the file so getVideoCreationDate can open it
-	tempFile.Close()
-
 	// Test getVideoCreationDate with an invalid video file
 	// This should return an error since the file isn't a valid video
-	date, err := getVideoCreationDate(tempFile.Name())
+	date, err := getVideoCreationDate(tempFile, false)
 
 	// We expect an error and a zero time
 	if err == nil {
@@ -519,14 +528,13 @@ func TestProcessFile(t *testing.T) {
 	}
 	defer logFile.Close()
 
-	// Initialize state and duplicates map
+	// Initialize state and the checksum registry
 	state := NewState(1)
-	duplicates := make(map[string][]string)
-	var mapLock sync.Mutex
+	registry := newRegistry(nil, nil, nil)
 
 	// Process the file
 	options := Options{MoveFiles: false}
-	err = processFile(testFilePath, destDir, duplicatesDir, noDataDir, duplicates, &mapLock, logFile, state, options)
+	err = processFile(testFilePath, true, destDir, duplicatesDir, noDataDir, registry, logFile, state, options)
 	if err != nil {
 		t.Fatalf("processFile returned an error: %v", err)
 	}
@@ -554,7 +562,7 @@ func TestProcessFile(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to open test file: %v", err)
 	}
-	checksum, err := calculateChecksum(file)
+	checksum, err := calculateChecksum(file, HashMD5)
 	file.Close()
 	if err != nil {
 		t.Fatalf("Failed to calculate checksum: %v", err)
@@ -566,11 +574,13 @@ func TestProcessFile(t *testing.T) {
 		t.Fatalf("Failed to create duplicate file: %v", err)
 	}
 
-	// Add the original file's path to the duplicates map
-	duplicates[checksum] = []string{expectedPath}
+	// The first file must have been registered as the original
+	if original, placed := registry.placed(checksum); !placed || original.Destination != expectedPath {
+		t.Errorf("Expected %s to be the original, got %q", expectedPath, original.Destination)
+	}
 
 	// Process the duplicate file
-	err = processFile(duplicateFilePath, destDir, duplicatesDir, noDataDir, duplicates, &mapLock, logFile, state, options)
+	err = processFile(duplicateFilePath, true, destDir, duplicatesDir, noDataDir, registry, logFile, state, options)
 	if err != nil {
 		t.Fatalf("processFile returned an error for duplicate: %v", err)
 	}
@@ -643,7 +653,7 @@ func TestProcessFiles(t *testing.T) {
 
 	// Process the files
 	options := Options{MoveFiles: false}
-	err = ProcessFiles(srcDir, destDir, logFilePath, state, messenger, options)
+	err = ProcessFiles(context.Background(), srcDir, destDir, logFilePath, state, messenger, options)
 	if err != nil {
 		t.Fatalf("ProcessFiles returned an error: %v", err)
 	}
@@ -687,3 +697,228 @@ func TestProcessFiles(t *testing.T) {
 		t.Errorf("Expected to find at least one processed file in destination directory")
 	}
 }
+
+// TestProcessFilesDryRun tests that a dry run records a plan without touching any files
+func TestProcessFilesDryRun(t *testing.T) {
+	// Create temporary directories for testing
+	tempDir, err := os.MkdirTemp("", "test-process-files-dry-run")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+
+	// Two files with the same name and content in different folders, plus a unique one
+	files := map[string]string{
+		"a/same.txt": "Same content",
+		"b/same.txt": "Same content",
+		"unique.txt": "Unique content",
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", name, err)
+		}
+	}
+
+	logFilePath := filepath.Join(tempDir, "test.log")
+	state := NewState(len(files))
+	plan := NewPlan(srcDir, destDir, false)
+
+	err = ProcessFiles(context.Background(), srcDir, destDir, logFilePath, state, NewMockMessenger(), Options{Plan: plan})
+	if err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+
+	// Nothing may be created during a dry run
+	if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+		t.Errorf("Expected destination directory not to be created during a dry run")
+	}
+	if _, err := os.Stat(logFilePath); !os.IsNotExist(err) {
+		t.Errorf("Expected log file not to be created during a dry run")
+	}
+
+	totals := plan.Totals()
+	if totals.NoData != 2 || totals.Duplicates != 1 {
+		t.Errorf("Expected 2 no-data entries and 1 duplicate, got %+v", totals)
+	}
+	if !state.IsDryRun() {
+		t.Errorf("Expected state to be marked as a dry run")
+	}
+	if state.GetPlannedBytes() != totals.Bytes {
+		t.Errorf("Expected state to report %d planned bytes, got %d", totals.Bytes, state.GetPlannedBytes())
+	}
+
+	// Every planned destination must be unique, even without files on disk
+	seen := make(map[string]bool)
+	for _, entry := range plan.Entries {
+		if seen[entry.Destination] {
+			t.Errorf("Destination %s was planned twice", entry.Destination)
+		}
+		seen[entry.Destination] = true
+		if entry.DateSource != DateSourceNone {
+			t.Errorf("Expected date source %q for %s, got %q", DateSourceNone, entry.Source, entry.DateSource)
+		}
+	}
+}
+
+// cancellingMessenger cancels a context after a number of messages have been sent
+type cancellingMessenger struct {
+	mu     sync.Mutex
+	count  int
+	after  int
+	cancel context.CancelFunc
+}
+
+func (m *cancellingMessenger) Send(msg interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.count++
+	if m.count == m.after {
+		m.cancel()
+	}
+}
+
+// TestProcessFilesCancel tests that cancelling stops processing without leaving partial files
+func TestProcessFilesCancel(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-files-cancel")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+
+	// Enough files that cancelling after the first one leaves most of them untouched
+	const totalFiles = 200
+	content := make([]byte, 64*1024)
+	for i := 0; i < totalFiles; i++ {
+		content[0], content[1] = byte(i), byte(i>>8)
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("file%03d.bin", i)), content, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	// A context that is already cancelled processes nothing
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	state := NewState(totalFiles)
+	err = ProcessFiles(cancelled, srcDir, destDir, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), Options{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if state.GetProcessedCount() != 0 {
+		t.Errorf("Expected no files to be processed, got %d", state.GetProcessedCount())
+	}
+
+	// Cancel once the first file has been processed (the first message announces the run)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messenger := &cancellingMessenger{after: 2, cancel: cancel}
+	state = NewState(totalFiles)
+	err = ProcessFiles(ctx, srcDir, destDir, filepath.Join(tempDir, "test.log"), state, messenger, Options{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if state.GetProcessedCount() == 0 || state.GetProcessedCount() >= totalFiles {
+		t.Errorf("Expected processing to stop part way, processed %d of %d", state.GetProcessedCount(), totalFiles)
+	}
+
+	// Every file that made it to the destination must be complete
+	copied := 0
+	err = filepath.Walk(destDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			copied++
+			if info.Size() != int64(len(content)) {
+				t.Errorf("Found partially written file %s (%d bytes)", path, info.Size())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk destination directory: %v", err)
+	}
+	if copied != state.GetProcessedCount() {
+		t.Errorf("Expected %d copied files, found %d", state.GetProcessedCount(), copied)
+	}
+}
+
+// TestProcessFilesPause tests that a paused run processes nothing until it is resumed
+func TestProcessFilesPause(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-files-pause")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	const totalFiles = 10
+	for i := 0; i < totalFiles; i++ {
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("file%d.txt", i)), []byte(fmt.Sprintf("Content of file %d", i)), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	state := NewState(totalFiles)
+	state.Pause()
+	if !state.IsPaused() || state.GetMessage() != "Paused" {
+		t.Errorf("Expected a paused state, got paused=%v message=%q", state.IsPaused(), state.GetMessage())
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), Options{})
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Expected ProcessFiles to wait while paused, returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if state.GetProcessedCount() != 0 {
+		t.Errorf("Expected no files to be processed while paused, got %d", state.GetProcessedCount())
+	}
+
+	state.Resume()
+	if err := <-done; err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+	if state.GetProcessedCount() != totalFiles {
+		t.Errorf("Expected %d processed files after resuming, got %d", totalFiles, state.GetProcessedCount())
+	}
+
+	// Cancelling a paused run releases the waiting workers
+	ctx, cancel := context.WithCancel(context.Background())
+	state = NewState(totalFiles)
+	state.Pause()
+	go func() {
+		done <- ProcessFiles(ctx, srcDir, filepath.Join(tempDir, "dest2"), filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), Options{})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if state.GetProcessedCount() != 0 {
+		t.Errorf("Expected no files to be processed, got %d", state.GetProcessedCount())
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package photo
+
+import "os"
+
+// copyOwner does nothing, as ownership is only copied on Unix systems.
+func copyOwner(info os.FileInfo, dest string) error {
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package photo
+
+import (
+	"os"
+	"syscall"
+)
+
+// copyOwner gives dest the user and group of the file described by info.
+func copyOwner(info os.FileInfo, dest string) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return os.Lchown(dest, int(stat.Uid), int(stat.Gid))
+}
@@ -0,0 +1,219 @@
+package photo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Action describes what will happen to a source file when a plan is executed.
+type Action string
+
+const (
+	ActionOrganize  Action = "organize"  // Copy or move into the YYYY/MM/DD tree
+	ActionNoData    Action = "nodata"    // Copy or move into the no-data directory
+	ActionDuplicate Action = "duplicate" // Copy or link into the duplicates directory, or skip
+)
+
+// Date sources recorded in a plan entry. HEIF and RAW files are dated from the EXIF data they
+// hold, so their dates come from DateSourceExif too.
+const (
+	DateSourceExif  = "exif"
+	DateSourceXMP   = "xmp"  // An XMP packet
+	DateSourceText  = "text" // The Creation Time text of a PNG image
+	DateSourceVideo = "video"
+	DateSourceNone  = "none"
+)
+
+// PlanEntry records the decision made for a single source file.
+type PlanEntry struct {
+	Source       string    `json:"source"`
+	Action       Action    `json:"action"`
+	Destination  string    `json:"destination"`
+	Checksum     string    `json:"checksum"`
+	DateSource   string    `json:"date_source"`
+	Size         int64     `json:"size"`
+	ModTime      time.Time `json:"mod_time"`
+	DuplicateOf  string    `json:"duplicate_of,omitempty"`
+	CollidesWith string    `json:"collides_with,omitempty"` // The original with the same checksum but different content
+}
+
+// Plan collects the decisions of a dry run so they can be reviewed before any file is touched.
+type Plan struct {
+	mu         sync.Mutex
+	SourceDir  string              `json:"source_dir"`
+	DestDir    string              `json:"dest_dir"`
+	MoveFiles  bool                `json:"move_files"`
+	Hasher     Hasher              `json:"hasher,omitempty"`     // Algorithm of the checksums, MD5 if empty
+	Duplicates LinkMode            `json:"duplicates,omitempty"` // How duplicates are placed, copied if empty
+	Originals  LinkMode            `json:"originals,omitempty"`  // How originals are placed unless moved, copied if empty
+	Entries    []PlanEntry         `json:"entries"`
+	reserved   map[string]struct{} // Destination paths already handed out by this plan
+}
+
+// PlanTotals summarizes a plan by action.
+type PlanTotals struct {
+	Organize   int
+	NoData     int
+	Duplicates int
+	Bytes      int64 // Total number of bytes that would be copied to the destination
+}
+
+// NewPlan creates an empty plan for the given source and destination directories.
+func NewPlan(srcDir, destDir string, moveFiles bool) *Plan {
+	return &Plan{
+		SourceDir: srcDir,
+		DestDir:   destDir,
+		MoveFiles: moveFiles,
+		Entries:   make([]PlanEntry, 0),
+		reserved:  make(map[string]struct{}),
+	}
+}
+
+// add safely appends an entry to the plan.
+func (p *Plan) add(entry PlanEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Entries = append(p.Entries, entry)
+}
+
+// demote turns the entry for source into a duplicate at destination of originalPath, and
+// points the duplicates of its previous destination at originalPath as well. It returns the
+// change in the number of bytes the entry would copy, which shrinks if duplicates are linked
+// or skipped.
+func (p *Plan) demote(source, destination, originalPath string) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	previous := ""
+	var change int64
+	for i := range p.Entries {
+		if p.Entries[i].Source == source {
+			change -= p.writtenBytes(p.Entries[i])
+			previous = p.Entries[i].Destination
+			p.Entries[i].Action = ActionDuplicate
+			p.Entries[i].Destination = destination
+			p.Entries[i].DuplicateOf = originalPath
+			change += p.writtenBytes(p.Entries[i])
+			break
+		}
+	}
+	for i := range p.Entries {
+		if previous != "" && p.Entries[i].DuplicateOf == previous {
+			p.Entries[i].DuplicateOf = originalPath
+		}
+	}
+	return change
+}
+
+// reserve returns a destination path that neither exists on disk nor has been
+// handed out to another entry of this plan, and marks it as taken.
+func (p *Plan) reserve(path string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	candidate := path
+	for i := 1; p.taken(candidate); i++ {
+		candidate = numberedPath(path, i)
+	}
+	p.reserved[candidate] = struct{}{}
+	return candidate
+}
+
+// taken reports whether a path is already in use. The caller must hold p.mu.
+func (p *Plan) taken(path string) bool {
+	if _, ok := p.reserved[path]; ok {
+		return true
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Totals returns the number of entries per action and the number of bytes the plan would copy.
+func (p *Plan) Totals() PlanTotals {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var totals PlanTotals
+	for _, entry := range p.Entries {
+		switch entry.Action {
+		case ActionOrganize:
+			totals.Organize++
+		case ActionNoData:
+			totals.NoData++
+		case ActionDuplicate:
+			totals.Duplicates++
+		}
+		totals.Bytes += p.writtenBytes(entry)
+	}
+	return totals
+}
+
+// writtenBytes returns the number of bytes an entry would copy to the destination, which is
+// none if it is linked or skipped. Links that turn out to be impossible are copied when the
+// plan is applied, which is not foreseen here. It only reads fields set before entries are
+// added, so it does not lock.
+func (p *Plan) writtenBytes(entry PlanEntry) int64 {
+	mode := p.Originals
+	if entry.Action == ActionDuplicate {
+		mode = p.Duplicates
+	} else if p.MoveFiles {
+		return entry.Size
+	}
+	if !mode.copies() {
+		return 0
+	}
+	return entry.Size
+}
+
+// Write encodes the plan as indented JSON. Entries are sorted by source path so that
+// plans from different runs can be compared with a plain diff.
+func (p *Plan) Write(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sort.Slice(p.Entries, func(i, j int) bool {
+		return p.Entries[i].Source < p.Entries[j].Source
+	})
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(p); err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+	return nil
+}
+
+// LoadPlan reads a plan previously written by WriteFile. The plan may have been
+// edited by hand in the meantime, so ApplyPlan validates it before use.
+func LoadPlan(path string) (*Plan, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open plan file: %w", err)
+	}
+	defer file.Close()
+
+	plan := NewPlan("", "", false)
+	if err := json.NewDecoder(file).Decode(plan); err != nil {
+		return nil, fmt.Errorf("failed to decode plan file %s: %w", path, err)
+	}
+	return plan, nil
+}
+
+// WriteFile writes the plan to the given path, replacing any existing file.
+func (p *Plan) WriteFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create plan file: %w", err)
+	}
+	defer file.Close()
+
+	if err := p.Write(file); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,86 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPlanReserve tests that reserve never hands out the same path twice
+func TestPlanReserve(t *testing.T) {
+	// Create a temporary directory for testing
+	tempDir, err := os.MkdirTemp("", "test-plan-reserve")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a file that already occupies the first candidate
+	existing := filepath.Join(tempDir, "photo.jpg")
+	if err := os.WriteFile(existing, []byte("test"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	plan := NewPlan(tempDir, tempDir, false)
+
+	first := plan.reserve(existing)
+	if expected := filepath.Join(tempDir, "photo_1.jpg"); first != expected {
+		t.Errorf("Expected first reservation to be %s, got %s", expected, first)
+	}
+
+	second := plan.reserve(existing)
+	if expected := filepath.Join(tempDir, "photo_2.jpg"); second != expected {
+		t.Errorf("Expected second reservation to be %s, got %s", expected, second)
+	}
+
+	free := plan.reserve(filepath.Join(tempDir, "other.jpg"))
+	if expected := filepath.Join(tempDir, "other.jpg"); free != expected {
+		t.Errorf("Expected free path to be reserved as is, got %s", free)
+	}
+}
+
+// TestPlanTotals tests the Totals method
+func TestPlanTotals(t *testing.T) {
+	plan := NewPlan("src", "dest", false)
+	plan.add(PlanEntry{Source: "a", Action: ActionOrganize, Size: 10})
+	plan.add(PlanEntry{Source: "b", Action: ActionOrganize, Size: 20})
+	plan.add(PlanEntry{Source: "c", Action: ActionNoData, Size: 5})
+	plan.add(PlanEntry{Source: "d", Action: ActionDuplicate, Size: 10})
+
+	totals := plan.Totals()
+	if totals.Organize != 2 || totals.NoData != 1 || totals.Duplicates != 1 {
+		t.Errorf("Unexpected totals: %+v", totals)
+	}
+	if totals.Bytes != 45 {
+		t.Errorf("Expected 45 planned bytes, got %d", totals.Bytes)
+	}
+}
+
+// TestPlanWrite tests that plans are written as JSON sorted by source path
+func TestPlanWrite(t *testing.T) {
+	plan := NewPlan("src", "dest", true)
+	plan.add(PlanEntry{Source: "src/b.jpg", Action: ActionDuplicate, Destination: "dest/duplicates/b.jpg"})
+	plan.add(PlanEntry{Source: "src/a.jpg", Action: ActionOrganize, Destination: "dest/2020/01/02/a_12345678.jpg"})
+
+	var buf bytes.Buffer
+	if err := plan.Write(&buf); err != nil {
+		t.Fatalf("Write returned an error: %v", err)
+	}
+
+	var decoded Plan
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Failed to decode written plan: %v", err)
+	}
+
+	if !decoded.MoveFiles || decoded.SourceDir != "src" || decoded.DestDir != "dest" {
+		t.Errorf("Plan header was not preserved: %s, %s, %v", decoded.SourceDir, decoded.DestDir, decoded.MoveFiles)
+	}
+	if len(decoded.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(decoded.Entries))
+	}
+	if decoded.Entries[0].Source != "src/a.jpg" || decoded.Entries[1].Source != "src/b.jpg" {
+		t.Errorf("Expected entries to be sorted by source, got %s, %s", decoded.Entries[0].Source, decoded.Entries[1].Source)
+	}
+}
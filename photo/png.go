@@ -0,0 +1,132 @@
+package photo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+)
+
+// pngSignature starts every PNG image.
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// pngTextDateLayouts are the forms of the Creation Time keyword of PNG text chunks besides XMP
+// dates: RFC 1123, as the PNG specification recommends, and the EXIF form that some tools write.
+var pngTextDateLayouts = []string{
+	time.RFC1123,
+	time.RFC1123Z,
+	"2006:01:02 15:04:05",
+}
+
+// getPNGCreationDate extracts the creation date of a PNG image of the given size. Its eXIf chunk
+// holds EXIF data without the JPEG wrapping, and its text chunks may hold an XMP packet or a
+// Creation Time. They are tried in that order, and the source of the date found is returned
+// with it; the image data chunks are skipped unread.
+func getPNGCreationDate(r io.ReaderAt, size int64) (time.Time, string) {
+	var signature [8]byte
+	if _, err := r.ReadAt(signature[:], 0); err != nil || string(signature[:]) != pngSignature {
+		return time.Time{}, DateSourceNone
+	}
+
+	var exifDate, xmpDate, textDate time.Time
+	for offset := int64(len(signature)); offset+12 <= size; {
+		var header [8]byte
+		if _, err := r.ReadAt(header[:], offset); err != nil {
+			break
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunkType := string(header[4:8])
+		if chunkType == "IEND" || length > size-offset-12 {
+			break
+		}
+
+		if (chunkType == "eXIf" || chunkType == "tEXt" || chunkType == "iTXt") && length <= maxMetadataBox {
+			data := make([]byte, length)
+			if _, err := r.ReadAt(data, offset+8); err != nil {
+				break
+			}
+			switch chunkType {
+			case "eXIf":
+				if exifDate.IsZero() {
+					exifDate = getPhotoCreationDate(bytes.NewReader(data), time.Time{})
+				}
+			case "tEXt":
+				keyword, text, _ := bytes.Cut(data, []byte{0})
+				pngTextDate(string(keyword), text, &xmpDate, &textDate)
+			case "iTXt":
+				if keyword, text, ok := readPNGInternationalText(data); ok {
+					pngTextDate(keyword, text, &xmpDate, &textDate)
+				}
+			}
+		}
+		offset += 12 + length // Length, type, data and CRC
+	}
+
+	switch {
+	case !exifDate.IsZero():
+		return exifDate, DateSourceExif
+	case !xmpDate.IsZero():
+		return xmpDate, DateSourceXMP
+	case !textDate.IsZero():
+		return textDate, DateSourceText
+	}
+	return time.Time{}, DateSourceNone
+}
+
+// pngTextDate records the date of a text chunk with the given keyword into xmpDate if it holds
+// an XMP packet, or into textDate if it is the Creation Time, unless one was found earlier.
+func pngTextDate(keyword string, text []byte, xmpDate, textDate *time.Time) {
+	switch keyword {
+	case "XML:com.adobe.xmp":
+		if xmpDate.IsZero() {
+			*xmpDate = getXMPCreationDate(text)
+		}
+	case "Creation Time":
+		if textDate.IsZero() {
+			*textDate = parsePNGTextDate(string(text))
+		}
+	}
+}
+
+// readPNGInternationalText returns the keyword and text of an iTXt chunk, decompressing the text
+// if needed. Between them are the compression flag and method, a language tag and a translated
+// keyword.
+func readPNGInternationalText(data []byte) (string, []byte, bool) {
+	keyword, rest, found := bytes.Cut(data, []byte{0})
+	if !found || len(rest) < 2 {
+		return "", nil, false
+	}
+	compressed := rest[0] == 1
+	fields := bytes.SplitN(rest[2:], []byte{0}, 3) // Language tag, translated keyword and text
+	if len(fields) != 3 {
+		return "", nil, false
+	}
+	text := fields[2]
+	if compressed {
+		z, err := zlib.NewReader(bytes.NewReader(text))
+		if err != nil {
+			return "", nil, false
+		}
+		defer z.Close()
+		text, err = io.ReadAll(io.LimitReader(z, maxMetadataBox))
+		if err != nil {
+			return "", nil, false
+		}
+	}
+	return string(keyword), text, true
+}
+
+// parsePNGTextDate parses the Creation Time of a PNG image, a free-form text that is usually in
+// one of pngTextDateLayouts or an XMP date.
+func parsePNGTextDate(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range pngTextDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date
+		}
+	}
+	date, _ := parseXMPDate(value)
+	return date
+}
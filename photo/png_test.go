@@ -0,0 +1,168 @@
+package photo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pngChunk returns a PNG chunk of the given type with its length and CRC
+func pngChunk(chunkType string, data []byte) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(len(data)))
+	b.WriteString(chunkType)
+	b.Write(data)
+	binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(chunkType), data...)))
+	return b.Bytes()
+}
+
+// pngFile returns a PNG image with the given metadata chunks after its header and image data
+func pngFile(chunks ...[]byte) []byte {
+	image := [][]byte{
+		[]byte(pngSignature),
+		pngChunk("IHDR", []byte{0, 0, 0, 1, 0, 0, 0, 1, 8, 0, 0, 0, 0}),
+		pngChunk("IDAT", bytes.Repeat([]byte{0x42}, 2048)),
+	}
+	image = append(image, chunks...)
+	return bytes.Join(append(image, pngChunk("IEND", nil)), nil)
+}
+
+// pngText returns a tEXt chunk with the given keyword and text
+func pngText(keyword, text string) []byte {
+	return pngChunk("tEXt", []byte(keyword+"\x00"+text))
+}
+
+// pngInternationalText returns an iTXt chunk with the given keyword and text, compressed if set
+func pngInternationalText(keyword string, text []byte, compressed bool) []byte {
+	flag := byte(0)
+	if compressed {
+		var z bytes.Buffer
+		w := zlib.NewWriter(&z)
+		w.Write(text)
+		w.Close()
+		text, flag = z.Bytes(), 1
+	}
+	return pngChunk("iTXt", append([]byte(keyword+"\x00"+string([]byte{flag, 0})+"\x00\x00"), text...))
+}
+
+// TestGetPNGCreationDate tests the dates read from the eXIf and text chunks of PNG images
+func TestGetPNGCreationDate(t *testing.T) {
+	xmp := xmpPacket(`ps:DateCreated="2020-05-06T07:08:09"/>`)
+	tests := []struct {
+		name     string
+		content  []byte
+		expected string
+		source   string
+	}{
+		{"eXIf", pngFile(pngChunk("eXIf", exifTIFF("2020:05:06 07:08:09"))), "2020-05-06 07:08:09", DateSourceExif},
+		{"XMP in iTXt", pngFile(pngInternationalText("XML:com.adobe.xmp", xmp, false)), "2020-05-06 07:08:09", DateSourceXMP},
+		{"Compressed XMP in iTXt", pngFile(pngInternationalText("XML:com.adobe.xmp", xmp, true)), "2020-05-06 07:08:09", DateSourceXMP},
+		{"XMP in tEXt", pngFile(pngText("XML:com.adobe.xmp", string(xmp))), "2020-05-06 07:08:09", DateSourceXMP},
+		{"Creation Time", pngFile(pngText("Creation Time", "Wed, 06 May 2020 07:08:09 GMT")), "2020-05-06 07:08:09", DateSourceText},
+		{"EXIF form of Creation Time", pngFile(pngText("Creation Time", "2020:05:06 07:08:09")), "2020-05-06 07:08:09", DateSourceText},
+		{"eXIf before Creation Time", pngFile(pngText("Creation Time", "2021:01:01 00:00:00"), pngChunk("eXIf", exifTIFF("2020:05:06 07:08:09"))), "2020-05-06 07:08:09", DateSourceExif},
+		{"XMP before Creation Time", pngFile(pngText("Creation Time", "2021:01:01 00:00:00"), pngText("XML:com.adobe.xmp", string(xmp))), "2020-05-06 07:08:09", DateSourceXMP},
+		{"Other text", pngFile(pngText("Software", "2020:05:06 07:08:09")), "", DateSourceNone},
+		{"Not a PNG image", []byte("This is not a valid image file"), "", DateSourceNone},
+	}
+
+	for _, test := range tests {
+		date, source := getPNGCreationDate(bytes.NewReader(test.content), int64(len(test.content)))
+		got := ""
+		if !date.IsZero() {
+			got = date.Format(time.DateTime)
+		}
+		if got != test.expected {
+			t.Errorf("%s: Expected %q, got %q", test.name, test.expected, got)
+		}
+		if source != test.source {
+			t.Errorf("%s: Expected date source %q, got %q", test.name, test.source, source)
+		}
+	}
+}
+
+// TestInspectFileChunkedImages tests that the date source of PNG, WebP and GIF images names the
+// metadata they were dated from
+func TestInspectFileChunkedImages(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-inspect-chunked")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	tests := map[string]struct {
+		content []byte
+		source  string
+	}{
+		"eXIf.png":  {pngFile(pngChunk("eXIf", exifTIFF("2022:07:08 09:10:11"))), DateSourceExif},
+		"xmp.png":   {pngFile(pngText("XML:com.adobe.xmp", string(xmpPacket(`xmp:CreateDate="2022-07-08T09:10:11"/>`)))), DateSourceXMP},
+		"text.png":  {pngFile(pngText("Creation Time", "2022:07:08 09:10:11")), DateSourceText},
+		"exif.webp": {webpFile(webpChunk("EXIF", exifTIFF("2022:07:08 09:10:11"))), DateSourceExif},
+		"xmp.webp":  {webpFile(webpChunk("XMP ", xmpPacket(`xmp:CreateDate="2022-07-08T09:10:11"/>`))), DateSourceXMP},
+		"xmp.gif":   {gifFile(xmpPacket(`xmp:CreateDate="2022-07-08T09:10:11"/>`)), DateSourceXMP},
+		"plain.gif": {gifFile(nil), DateSourceNone},
+	}
+
+	destDir := filepath.Join(tempDir, "dest")
+	for name, test := range tests {
+		srcPath := filepath.Join(tempDir, name)
+		if err := os.WriteFile(srcPath, test.content, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+
+		entry, err := inspectFile(srcPath, false, destDir, filepath.Join(destDir, "nodata"), nil, HashMD5, false)
+		if err != nil {
+			t.Fatalf("%s: inspectFile returned an error: %v", name, err)
+		}
+		if entry.DateSource != test.source {
+			t.Errorf("%s: Expected date source %q, got %q", name, test.source, entry.DateSource)
+		}
+	}
+}
+
+// TestProcessFilesChunkedImages tests that PNG, WebP and GIF images are organized by their
+// metadata and copied in full
+func TestProcessFilesChunkedImages(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-chunked-images")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"Screenshot.png": string(pngFile(pngInternationalText("XML:com.adobe.xmp", xmpPacket(`ps:DateCreated="2022-07-08T09:10:11"/>`), false))),
+		"photo.webp":     string(webpFile(webpChunk("EXIF", exifTIFF("2022:07:08 12:13:14")))),
+		"animation.gif":  string(gifFile(xmpPacket(`xmp:CreateDate="2022-07-08T15:16:17"/>`))),
+		"plain.png":      string(pngFile()),
+	}
+	srcDir := filepath.Join(tempDir, "src")
+	createLibrary(t, srcDir, files)
+
+	destDir := filepath.Join(tempDir, "dest")
+	state := NewState(len(files))
+	if err := ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), Options{}); err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+	if state.GetNoDataCount() != 1 {
+		t.Errorf("Expected the PNG image without metadata in nodata, got %d files", state.GetNoDataCount())
+	}
+
+	organized, _ := os.ReadDir(filepath.Join(destDir, "2022", "07", "08"))
+	if len(organized) != len(files)-1 {
+		t.Fatalf("Expected %d organized images, found %d", len(files)-1, len(organized))
+	}
+	for _, entry := range organized {
+		copied, _ := os.ReadFile(filepath.Join(destDir, "2022", "07", "08", entry.Name()))
+		name := strings.Replace(entry.Name(), "_"+md5Hex(string(copied))[:8], "", 1)
+		if string(copied) != files[name] {
+			t.Errorf("Expected %s to be a complete copy of %s", entry.Name(), name)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package photo
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// foundFile is a file found while walking the source directory.
+type foundFile struct {
+	path string
+	size int64
+}
+
+// collectFiles walks srcDir and returns every file in it, ignoring directories and hidden files.
+// Cancelling ctx stops the walk and returns ctx.Err().
+func collectFiles(ctx context.Context, srcDir string) ([]foundFile, error) {
+	var files []foundFile
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			files = append(files, foundFile{path: path, size: info.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// partialHashSize is the number of bytes hashed at each end of a file by the partial hash stage.
+const partialHashSize = 64 * 1024
+
+// hashStage is the stage of the prefilter at which a file was found to need no duplicate check.
+type hashStage int
+
+const (
+	stageSize    hashStage = iota // No other file has the same size
+	stagePartial                  // No other file of the same size has the same head and tail
+	stageFull                     // The file needs a full checksum
+)
+
+// partialKey identifies files that may be identical after the partial hash stage.
+type partialKey struct {
+	size    int64
+	partial string
+}
+
+// prefilter tells, judging by size and by the first and last bytes, whether a file can have
+// a duplicate. Files of different sizes never have the same content, and most files of the
+// same size already differ in their first block, so only the remaining files need a full
+// checksum before they are compared.
+type prefilter struct {
+	counts   map[int64]int      // size -> number of source files
+	partials map[string]string  // path -> partial hash, for files whose size collides
+	matches  map[partialKey]int // size and partial hash -> number of source files
+	library  *Library
+	index    *Index
+}
+
+// newPrefilter counts the sizes of the source files.
+func newPrefilter(files []foundFile, library *Library, index *Index) *prefilter {
+	counts := make(map[int64]int, len(files))
+	for _, file := range files {
+		counts[file.size]++
+	}
+	return &prefilter{
+		counts:   counts,
+		partials: make(map[string]string),
+		matches:  make(map[partialKey]int),
+		library:  library,
+		index:    index,
+	}
+}
+
+// hashPartials runs the partial hash stage over the source files whose size is shared only
+// with other source files. Files in the destination are not read, so a size shared with the
+// destination always leads to a full checksum. Files that cannot be read are left to the
+// full checksum as well.
+func (f *prefilter) hashPartials(ctx context.Context, files []foundFile, hasher Hasher, state *State) error {
+	var candidates []foundFile
+	for _, file := range files {
+		if f.counts[file.size] > 1 && !f.inDestination(file.size) {
+			candidates = append(candidates, file)
+		}
+	}
+
+	var lock sync.Mutex
+	return forEach(ctx, candidates, state, func(file foundFile) {
+		partial, err := partialHash(file.path, file.size, hasher)
+		if err != nil {
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		f.partials[file.path] = partial
+		f.matches[partialKey{file.size, partial}]++
+	})
+}
+
+// stage returns the stage at which file was found to have no possible duplicate, or
+// stageFull if it needs a full checksum.
+func (f *prefilter) stage(file foundFile) hashStage {
+	if f.inDestination(file.size) {
+		return stageFull
+	}
+	if f.counts[file.size] < 2 {
+		return stageSize
+	}
+	partial, hashed := f.partials[file.path]
+	if hashed && f.matches[partialKey{file.size, partial}] < 2 {
+		return stagePartial
+	}
+	return stageFull
+}
+
+// inDestination reports whether a file in the destination has the given size.
+func (f *prefilter) inDestination(size int64) bool {
+	return f.library.hasSize(size) || f.index.hasSize(size)
+}
+
+// partialHash hashes the first and last partialHashSize bytes of a file of the given size.
+// Files of up to twice that size are hashed completely.
+func partialHash(path string, size int64, hasher Hasher) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	hash := hasher.New()
+	if size <= 2*partialHashSize {
+		_, err = io.Copy(hash, file)
+	} else {
+		_, err = io.Copy(hash, io.NewSectionReader(file, 0, partialHashSize))
+		if err == nil {
+			_, err = io.Copy(hash, io.NewSectionReader(file, size-partialHashSize, partialHashSize))
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// placeUnhashed organizes a file that cannot have a duplicate from its inspected source. Its
+// checksum is calculated while it is copied, so the file is read only once; the organized
+// name, which embeds the checksum prefix, is chosen once the copy is complete.
+func placeUnhashed(entry PlanEntry, source *sourceFile, registry *registry, state *State, options Options) error {
+	destFolder := filepath.Dir(entry.Destination)
+	if err := os.MkdirAll(destFolder, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", destFolder, err)
+	}
+
+	tempPath, checksum, err := copyHashed(source, destFolder, options.Preserve)
+	if err != nil {
+		return fmt.Errorf("failed to copy file %s: %w", entry.Source, err)
+	}
+
+	entry.Checksum = checksum
+	if entry.Action == ActionOrganize {
+		entry.Destination = organizedPath(destFolder, entry.Source, checksum)
+	}
+	entry.Destination, err = resolveNamingConflict(registry.reserve(entry.Destination), func(dest string) error {
+		return renameTempFile(tempPath, dest)
+	})
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename copy of %s: %w", entry.Source, err)
+	}
+
+	if entry.Action == ActionNoData {
+		state.IncrementNoData()
+	} else {
+		state.IncrementUnique()
+	}
+	return recordOriginal(entry, nil, options)
+}
+
+// copyHashed copies source with the metadata selected by preserve to a temporary file in
+// destFolder and calculates the checksum of the copied content. The temporary file is flushed
+// to disk, ready to be renamed into place, and removed if anything fails.
+func copyHashed(source *sourceFile, destFolder string, preserve Preserve) (string, string, error) {
+	sourceInfo, err := source.file.Stat()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get source file info: %w", err)
+	}
+
+	tempFile, err := createTempFile(destFolder)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	checksum, err := source.copyTo(tempFile)
+	if err == nil {
+		err = finishTempFile(tempFile, sourceInfo.Mode())
+	} else {
+		tempFile.Close()
+	}
+	if err == nil {
+		err = preserveMetadata(source.file.Name(), sourceInfo, tempFile.Name(), preserve)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", "", fmt.Errorf("failed to copy file contents: %w", err)
+	}
+	return tempFile.Name(), checksum, nil
+}
@@ -0,0 +1,275 @@
+package photo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPrefilterSizes tests that only files whose size is shared with another file need a checksum
+func TestPrefilterSizes(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-size-filter")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	createLibrary(t, srcDir, map[string]string{
+		"a.jpg":  "12345",
+		"b.jpg":  "abcde",
+		"c.jpg":  "123",
+		"d.jpg":  "1234567",
+		".x.jpg": "123456789", // Hidden files are not collected
+	})
+	createLibrary(t, destDir, map[string]string{
+		filepath.Join("nodata", "old.jpg"): "xxxxxxx",
+	})
+
+	files, err := collectFiles(context.Background(), srcDir)
+	if err != nil {
+		t.Fatalf("collectFiles returned an error: %v", err)
+	}
+	if len(files) != 4 {
+		t.Fatalf("Expected 4 files, got %d", len(files))
+	}
+
+	library, err := ScanLibrary(context.Background(), destDir, nil, HashMD5, NewState(0), NewMockMessenger())
+	if err != nil {
+		t.Fatalf("ScanLibrary returned an error: %v", err)
+	}
+	filter := newPrefilter(files, library, nil)
+
+	tests := []struct {
+		size     int64
+		expected hashStage
+	}{
+		{5, stageFull}, // Two source files
+		{3, stageSize}, // A single source file
+		{7, stageFull}, // Shared with the library
+		{9, stageSize}, // Only a hidden file
+	}
+	for _, test := range tests {
+		if result := filter.stage(foundFile{size: test.size}); result != test.expected {
+			t.Errorf("stage(%d) = %v, expected %v", test.size, result, test.expected)
+		}
+	}
+}
+
+// TestPrefilterPartials tests that files of the same size are told apart by their first and last bytes
+func TestPrefilterPartials(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-prefilter-partials")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	body := strings.Repeat("x", 3*partialHashSize)
+	middle := len(body) / 2
+	createLibrary(t, tempDir, map[string]string{
+		"head-1.jpg":   "1" + body,
+		"head-2.jpg":   "2" + body,
+		"same-1.jpg":   "3" + body,
+		"same-2.jpg":   "3" + body,
+		"middle-1.jpg": "4" + body[:middle] + "a" + body[middle:],
+		"middle-2.jpg": "4" + body[:middle] + "b" + body[middle:],
+		"small-1.jpg":  "small 1",
+		"small-2.jpg":  "small 2",
+	})
+
+	files, err := collectFiles(context.Background(), tempDir)
+	if err != nil {
+		t.Fatalf("collectFiles returned an error: %v", err)
+	}
+	filter := newPrefilter(files, nil, nil)
+	if err := filter.hashPartials(context.Background(), files, HashMD5, NewState(len(files))); err != nil {
+		t.Fatalf("hashPartials returned an error: %v", err)
+	}
+
+	expected := map[string]hashStage{
+		"head-1.jpg":   stagePartial,
+		"head-2.jpg":   stagePartial,
+		"same-1.jpg":   stageFull,
+		"same-2.jpg":   stageFull,
+		"middle-1.jpg": stageFull, // Only a full checksum tells these apart
+		"middle-2.jpg": stageFull,
+		"small-1.jpg":  stagePartial, // Small files are hashed completely
+		"small-2.jpg":  stagePartial,
+	}
+	for _, file := range files {
+		if stage := filter.stage(file); stage != expected[filepath.Base(file.path)] {
+			t.Errorf("Expected stage %v for %s, got %v", expected[filepath.Base(file.path)], filepath.Base(file.path), stage)
+		}
+	}
+}
+
+// TestCopyHashed tests that a copy made while hashing matches the source and its checksum
+func TestCopyHashed(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-copy-hashed")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	content := strings.Repeat("Hashed while copied. ", 10000)
+	srcPath := filepath.Join(tempDir, "source.mov")
+	if err := os.WriteFile(srcPath, []byte(content), 0640); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+
+	source, err := openSource(srcPath, HashMD5, true)
+	if err != nil {
+		t.Fatalf("Failed to open source file: %v", err)
+	}
+	defer source.Close()
+	tempPath, checksum, err := copyHashed(source, tempDir, Preserve{})
+	if err != nil {
+		t.Fatalf("copyHashed returned an error: %v", err)
+	}
+	if checksum != md5Hex(content) {
+		t.Errorf("Expected checksum %s, got %s", md5Hex(content), checksum)
+	}
+	if !strings.HasPrefix(filepath.Base(tempPath), ".") {
+		t.Errorf("Expected a hidden temporary file, got %s", tempPath)
+	}
+	copied, err := os.ReadFile(tempPath)
+	if err != nil {
+		t.Fatalf("Failed to read copy: %v", err)
+	}
+	if string(copied) != content {
+		t.Errorf("Copied content doesn't match the source")
+	}
+	info, err := os.Stat(tempPath)
+	if err != nil {
+		t.Fatalf("Failed to stat copy: %v", err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Expected permissions 0640, got %v", info.Mode().Perm())
+	}
+
+	// A source that cannot be read leaves nothing behind
+	source.Close()
+	if _, _, err := copyHashed(source, tempDir, Preserve{}); err == nil {
+		t.Errorf("Expected an error for an unreadable source")
+	}
+	entries, _ := os.ReadDir(tempDir)
+	if len(entries) != 2 {
+		t.Errorf("Expected only the source and the copy, found %d files", len(entries))
+	}
+}
+
+// TestProcessFilesSizePrefilter tests that files with unique sizes are organized and indexed like any other file
+func TestProcessFilesSizePrefilter(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-files-prefilter")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	files := map[string]string{
+		"unique.mov":    "A video no other file has the size of",
+		"same-size.jpg": "Same size, different 1",
+		"other.jpg":     "Same size, different 2",
+		"copy.jpg":      "Same size, different 1",
+	}
+	createLibrary(t, srcDir, files)
+
+	index, err := OpenIndex(filepath.Join(destDir, IndexFileName), HashMD5)
+	if err != nil {
+		t.Fatalf("OpenIndex returned an error: %v", err)
+	}
+	state := NewState(len(files))
+	err = ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), Options{Index: index})
+	if err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+	index.Close()
+
+	if state.GetNoDataCount() != 3 || state.GetDuplicateCount() != 1 || state.GetErrorCount() != 0 {
+		t.Errorf("Expected 3 originals, 1 duplicate and no errors, got %d, %d and %d", state.GetNoDataCount(), state.GetDuplicateCount(), state.GetErrorCount())
+	}
+
+	// No temporary files are left behind and the unique file carries its real checksum
+	entries, err := os.ReadDir(filepath.Join(destDir, "nodata"))
+	if err != nil {
+		t.Fatalf("Failed to read no-data directory: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			t.Errorf("Unexpected temporary file %s", entry.Name())
+		}
+	}
+	reopened, err := OpenIndex(filepath.Join(destDir, IndexFileName), HashMD5)
+	if err != nil {
+		t.Fatalf("OpenIndex returned an error: %v", err)
+	}
+	if path := reopened.Originals()[md5Hex(files["unique.mov"])]; path != filepath.Join(destDir, "nodata", "unique.mov") {
+		t.Errorf("Expected the unique file to be indexed with its checksum, got %q", path)
+	}
+}
+
+// TestInspectFileWithoutHash tests that an unhashed file is inspected without a checksum
+func TestInspectFileWithoutHash(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-inspect-file")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcPath := filepath.Join(tempDir, "clip.txt")
+	if err := os.WriteFile(srcPath, []byte("Not hashed"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	noDataDir := filepath.Join(tempDir, "dest", "nodata")
+	entry, err := inspectFile(srcPath, false, filepath.Join(tempDir, "dest"), noDataDir, nil, HashMD5, false)
+	if err != nil {
+		t.Fatalf("inspectFile returned an error: %v", err)
+	}
+	if entry.Checksum != "" {
+		t.Errorf("Expected no checksum, got %s", entry.Checksum)
+	}
+	if entry.Destination != filepath.Join(noDataDir, "clip.txt") || entry.Size != int64(len("Not hashed")) {
+		t.Errorf("Unexpected entry: %s (%d bytes)", entry.Destination, entry.Size)
+	}
+}
+
+// TestProcessFilesHashStages tests that the state reports how many files each stage of the duplicate check settled
+func TestProcessFilesHashStages(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-files-stages")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	body := strings.Repeat("y", 3*partialHashSize)
+	files := map[string]string{
+		"unique.mov": "No other file has this size",
+		"head-1.jpg": "1" + body,
+		"head-2.jpg": "2" + body,
+		"copy-1.jpg": "3" + body,
+		"copy-2.jpg": "3" + body,
+	}
+	createLibrary(t, srcDir, files)
+
+	state := NewState(len(files))
+	err = ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), Options{})
+	if err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+
+	if state.GetSizeUniqueCount() != 1 || state.GetHeadUniqueCount() != 2 || state.GetFullHashCount() != 2 {
+		t.Errorf("Expected 1, 2 and 2 files settled by size, head and tail, and full checksum, got %d, %d and %d",
+			state.GetSizeUniqueCount(), state.GetHeadUniqueCount(), state.GetFullHashCount())
+	}
+	if state.GetNoDataCount() != 4 || state.GetDuplicateCount() != 1 || state.GetErrorCount() != 0 {
+		t.Errorf("Expected 4 originals, 1 duplicate and no errors, got %d, %d and %d", state.GetNoDataCount(), state.GetDuplicateCount(), state.GetErrorCount())
+	}
+}
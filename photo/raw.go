@@ -0,0 +1,113 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+)
+
+// rawFormats names the camera RAW formats by their extension.
+var rawFormats = map[string]string{
+	".cr2": "CR2", // Canon, TIFF-based
+	".cr3": "CR3", // Canon, in an ISO base media container
+	".nef": "NEF", // Nikon, TIFF-based
+	".arw": "ARW", // Sony, TIFF-based
+	".dng": "DNG", // Adobe's open format, also written by phones; TIFF-based
+	".raf": "RAF", // Fujifilm, with the EXIF data in an embedded JPEG
+	".orf": "ORF", // Olympus, TIFF with a magic number of its own
+}
+
+// cr3MetadataUUID identifies the Canon box of a CR3 file that holds its TIFF metadata.
+var cr3MetadataUUID = []byte{0x85, 0xC0, 0xB6, 0x87, 0x82, 0x0F, 0x11, 0xE0, 0x81, 0x11, 0xF4, 0xCE, 0x46, 0x2B, 0x6A, 0x48}
+
+// rafMagic starts every Fujifilm RAF file.
+const rafMagic = "FUJIFILMCCD-RAW "
+
+// rawFormat returns the name of the camera RAW format of extension, or "" for other files.
+func rawFormat(extension string) string {
+	return rawFormats[strings.ToLower(extension)]
+}
+
+// rawDate extracts the EXIF creation date of a camera RAW file of the given format and size.
+// TIFF-based formats are decoded like other photos, in the pass that hashes them. CR3 and RAF
+// files keep their EXIF data inside a container, which is read at its offsets instead.
+func (s *sourceFile) rawDate(format string, size int64) time.Time {
+	switch format {
+	case "CR3":
+		return getCR3CreationDate(s.file, size)
+	case "RAF":
+		return getRAFCreationDate(s.file, size)
+	case "ORF":
+		return getPhotoCreationDate(orfTIFF(s.reader()), time.Time{})
+	}
+	return s.photoDate()
+}
+
+// getCR3CreationDate extracts the EXIF creation date of a CR3 file of the given size. Its movie
+// box holds a Canon box with the TIFF structures of the EXIF data: CMT1 has the main IFD with
+// the time of the last change and CMT2 the Exif IFD with the time the photo was taken.
+func getCR3CreationDate(r io.ReaderAt, size int64) time.Time {
+	top, _ := readBoxes(r, 0, size)
+	moov, found := findBox(top, "moov")
+	if !found {
+		return time.Time{}
+	}
+	boxes, _ := moov.children(r)
+	for _, canon := range boxes {
+		var id [16]byte
+		if canon.boxType != "uuid" || canon.size < int64(len(id)) {
+			continue
+		}
+		if _, err := r.ReadAt(id[:], canon.offset); err != nil || !bytes.Equal(id[:], cr3MetadataUUID) {
+			continue
+		}
+
+		metadata, _ := readBoxes(r, canon.offset+int64(len(id)), canon.offset+canon.size)
+		for _, name := range []string{"CMT2", "CMT1"} {
+			cmt, found := findBox(metadata, name)
+			if !found {
+				continue
+			}
+			tiff, err := cmt.payload(r)
+			if err != nil {
+				continue
+			}
+			if date := getPhotoCreationDate(bytes.NewReader(tiff), time.Time{}); !date.IsZero() {
+				return date
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// getRAFCreationDate extracts the EXIF creation date of a RAF file of the given size from the
+// JPEG preview it embeds, whose offset and length follow the header. The decoder stops reading
+// the preview once it has found the EXIF data.
+func getRAFCreationDate(r io.ReaderAt, size int64) time.Time {
+	var header [92]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil || string(header[:len(rafMagic)]) != rafMagic {
+		return time.Time{}
+	}
+	offset := int64(binary.BigEndian.Uint32(header[84:88]))
+	length := int64(binary.BigEndian.Uint32(header[88:92]))
+	if offset < int64(len(header)) || offset+length > size {
+		return time.Time{}
+	}
+	return getPhotoCreationDate(io.NewSectionReader(r, offset, length), time.Time{})
+}
+
+// orfTIFF returns a reader of an ORF file that starts with the standard TIFF magic number.
+// Olympus writes its own instead (IIRO, IIRS or MMOR), which TIFF decoders reject.
+func orfTIFF(r io.Reader) io.Reader {
+	var header [4]byte
+	n, _ := io.ReadFull(r, header[:])
+	switch string(header[:n]) {
+	case "IIRO", "IIRS":
+		copy(header[2:], "*\x00")
+	case "MMOR":
+		copy(header[2:], "\x00*")
+	}
+	return io.MultiReader(bytes.NewReader(header[:n]), r)
+}
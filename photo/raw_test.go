@@ -0,0 +1,143 @@
+package photo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// rawFiles returns a synthetic file of every supported camera RAW format, each dated
+// 2019-04-05 and with a distinct size so that no two are hashed up front
+func rawFiles() map[string][]byte {
+	imageData := func(n int) []byte { return bytes.Repeat([]byte{0x42}, 4096+n) }
+	tiff := func(n int) []byte { return append(exifTIFF("2019:04:05 06:07:08"), imageData(n)...) }
+
+	orf := tiff(5)
+	copy(orf, "IIRO")
+
+	// CMT1 records when the photo was last changed, CMT2 when it was taken
+	canon := isoBox("uuid", cr3MetadataUUID,
+		isoBox("CMT1", exifTIFF("2020:01:01 00:00:00")),
+		isoBox("CMT2", exifTIFF("2019:04:05 06:07:08")),
+	)
+	cr3 := bytes.Join([][]byte{
+		isoBox("ftyp", []byte("crx \x00\x00\x00\x01crx isom")),
+		isoBox("moov", canon),
+		isoBox("mdat", imageData(6)),
+	}, nil)
+
+	// The JPEG preview of a RAF file follows its header, at the recorded offset
+	preview := exifJPEG("2019:04:05 06:07:08", 1024)
+	var raf bytes.Buffer
+	raf.WriteString(rafMagic)
+	raf.WriteString("0201FF129502")
+	raf.Write(make([]byte, 84-raf.Len()))
+	binary.Write(&raf, binary.BigEndian, uint32(100))
+	binary.Write(&raf, binary.BigEndian, uint32(len(preview)))
+	raf.Write(make([]byte, 100-raf.Len()))
+	raf.Write(preview)
+	raf.Write(imageData(7))
+
+	return map[string][]byte{
+		"IMG_0001.CR2": tiff(1),
+		"DSC_0001.NEF": tiff(2),
+		"DSC00001.ARW": tiff(3),
+		"PXL_0001.dng": tiff(4),
+		"P0000001.ORF": orf,
+		"IMG_0001.CR3": cr3,
+		"DSCF0001.RAF": raf.Bytes(),
+	}
+}
+
+// TestRawFormat tests that RAW formats are recognized by their extension
+func TestRawFormat(t *testing.T) {
+	tests := map[string]string{
+		".cr2": "CR2", ".CR3": "CR3", ".nef": "NEF", ".ARW": "ARW",
+		".dng": "DNG", ".raf": "RAF", ".orf": "ORF", ".jpg": "", "": "",
+	}
+	for extension, expected := range tests {
+		if format := rawFormat(extension); format != expected {
+			t.Errorf("rawFormat(%s) = %q, expected %q", extension, format, expected)
+		}
+	}
+}
+
+// TestInspectFileRaw tests that every RAW format is dated from its EXIF data and hashed in full
+func TestInspectFileRaw(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-inspect-raw")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	destDir := filepath.Join(tempDir, "dest")
+	for name, content := range rawFiles() {
+		srcPath := filepath.Join(tempDir, name)
+		if err := os.WriteFile(srcPath, content, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+
+		entry, err := inspectFile(srcPath, true, destDir, filepath.Join(destDir, "nodata"), nil, HashMD5, false)
+		if err != nil {
+			t.Fatalf("%s: inspectFile returned an error: %v", name, err)
+		}
+		if !strings.HasPrefix(entry.Destination, filepath.Join(destDir, "2019", "04", "05")) {
+			t.Errorf("%s: Expected a destination for 2019-04-05, got %s", name, entry.Destination)
+		}
+		if entry.DateSource != DateSourceExif {
+			t.Errorf("%s: Expected date source %q, got %q", name, DateSourceExif, entry.DateSource)
+		}
+		if entry.Checksum != md5Hex(string(content)) {
+			t.Errorf("%s: Expected checksum %s, got %s", name, md5Hex(string(content)), entry.Checksum)
+		}
+	}
+}
+
+// TestProcessFilesRawFormats tests that RAW files are copied in full and counted by format once dated
+func TestProcessFilesRawFormats(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-raw-formats")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := make(map[string]string)
+	for name, content := range rawFiles() {
+		files[name] = string(content)
+	}
+	files["undated.nef"] = "A NEF file without EXIF data"
+	srcDir := filepath.Join(tempDir, "src")
+	createLibrary(t, srcDir, files)
+
+	destDir := filepath.Join(tempDir, "dest")
+	state := NewState(len(files))
+	if err := ProcessFiles(context.Background(), srcDir, destDir, filepath.Join(tempDir, "test.log"), state, NewMockMessenger(), Options{}); err != nil {
+		t.Fatalf("ProcessFiles returned an error: %v", err)
+	}
+
+	counts := state.GetRawFormatCounts()
+	for _, format := range []string{"CR2", "CR3", "NEF", "ARW", "DNG", "RAF", "ORF"} {
+		if counts[format] != 1 {
+			t.Errorf("Expected 1 dated %s file, got %d", format, counts[format])
+		}
+	}
+	if state.GetNoDataCount() != 1 {
+		t.Errorf("Expected the undated NEF file in nodata, got %d files", state.GetNoDataCount())
+	}
+
+	organized, _ := os.ReadDir(filepath.Join(destDir, "2019", "04", "05"))
+	if len(organized) != len(files)-1 {
+		t.Fatalf("Expected %d organized files, found %d", len(files)-1, len(organized))
+	}
+	for _, entry := range organized {
+		copied, _ := os.ReadFile(filepath.Join(destDir, "2019", "04", "05", entry.Name()))
+		name := strings.Replace(entry.Name(), "_"+md5Hex(string(copied))[:8], "", 1)
+		if string(copied) != files[name] {
+			t.Errorf("Expected %s to be a complete copy of %s", entry.Name(), name)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package photo
+
+import (
+	"os"
+	"sync"
+)
+
+// claimOutcome tells the caller of registry.claim what to do with a file.
+type claimOutcome int
+
+const (
+	claimOriginal  claimOutcome = iota // The file is the original; the caller must settle or release the claim
+	claimDuplicate                     // The file is a duplicate of the returned original
+	claimReplace                       // The file replaces the returned original; the caller must replace or settle the claim
+)
+
+// registry decides which file is the original for each checksum and hands out destination
+// paths. Only these decisions are made under its lock; copying and moving happen outside
+// of it, so workers can do their I/O in parallel. It is safe for concurrent use.
+type registry struct {
+	mu        sync.Mutex
+	originals map[string]*original // checksum -> original claim
+	reserved  map[string]struct{}  // Destination paths handed out during this run
+	library   *Library
+	plan      *Plan
+}
+
+// original is the claim on a checksum. The library is consulted once per checksum.
+type original struct {
+	lookup  sync.Once
+	placing sync.Mutex // Held while the original is being placed or replaced
+	path    string     // Destination of the original, empty while unclaimed
+	entry   PlanEntry  // The original if it was organized by this run, zero for earlier imports
+}
+
+// newRegistry creates a registry seeded with the originals organized by previous runs. During
+// a dry run, destination paths are reserved in plan instead.
+func newRegistry(seed map[string]string, library *Library, plan *Plan) *registry {
+	r := &registry{
+		originals: make(map[string]*original, len(seed)),
+		reserved:  make(map[string]struct{}),
+		library:   library,
+		plan:      plan,
+	}
+	for checksum, path := range seed {
+		r.originals[checksum] = &original{path: path}
+	}
+	return r
+}
+
+// claim decides whether entry is the original for its checksum. The first claim becomes the
+// original and has entry.Destination reserved for it. Later claims are duplicates, unless
+// keeper is set and prefers entry over an original organized by this run; such a claim waits
+// until the original has been placed and gets a destination reserved to replace it.
+// The returned original has its Destination set to where the original is organized.
+func (r *registry) claim(entry PlanEntry, keeper *KeeperPolicy) (string, PlanEntry, claimOutcome) {
+	r.mu.Lock()
+	claimed, exists := r.originals[entry.Checksum]
+	if !exists {
+		claimed = &original{}
+		r.originals[entry.Checksum] = claimed
+	}
+	r.mu.Unlock()
+
+	// Content that is not known yet may still exist in the library from earlier imports
+	claimed.lookup.Do(func() {
+		r.mu.Lock()
+		known := claimed.path != ""
+		r.mu.Unlock()
+		if known {
+			return
+		}
+		if libraryPath, found := r.library.lookup(entry.Checksum, entry.Size); found {
+			r.mu.Lock()
+			claimed.path = libraryPath
+			r.mu.Unlock()
+		}
+	})
+
+	// Contesting an original requires it to be in place first. Without a keeper, duplicates
+	// never wait, and placing is free whenever path is empty.
+	if keeper != nil {
+		claimed.placing.Lock()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if claimed.path == "" {
+		if keeper == nil {
+			claimed.placing.Lock()
+		}
+		claimed.path = r.reserveLocked(entry.Destination)
+		claimed.entry = entry
+		claimed.entry.Destination = claimed.path
+		return claimed.path, PlanEntry{}, claimOriginal
+	}
+
+	current := claimed.entry
+	if current.Source == "" {
+		current = PlanEntry{Checksum: entry.Checksum, Destination: claimed.path}
+	}
+	if keeper != nil && claimed.entry.Source != "" && keeper.prefers(entry, claimed.entry) {
+		return r.reserveLocked(entry.Destination), current, claimReplace
+	}
+	if keeper != nil {
+		claimed.placing.Unlock()
+	}
+	return "", current, claimDuplicate
+}
+
+// placed waits until the original for checksum is in place and returns it with its Destination
+// set. It returns false if the original could not be placed.
+func (r *registry) placed(checksum string) (PlanEntry, bool) {
+	r.mu.Lock()
+	claimed := r.originals[checksum]
+	r.mu.Unlock()
+
+	claimed.placing.Lock()
+	defer claimed.placing.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if claimed.path == "" {
+		return PlanEntry{}, false
+	}
+	if claimed.entry.Source == "" {
+		return PlanEntry{Checksum: checksum, Destination: claimed.path}, true
+	}
+	return claimed.entry, true
+}
+
+// settle ends a claim once the original has been placed, or once a replacement was given up.
+func (r *registry) settle(checksum string) {
+	r.mu.Lock()
+	claimed := r.originals[checksum]
+	r.mu.Unlock()
+	claimed.placing.Unlock()
+}
+
+// settleAt ends a claim once the original has been placed at entry.Destination, which differs
+// from the reserved path if another process took that name first.
+func (r *registry) settleAt(entry PlanEntry) {
+	r.mu.Lock()
+	claimed := r.originals[entry.Checksum]
+	claimed.path = entry.Destination
+	claimed.entry = entry
+	r.reserved[entry.Destination] = struct{}{}
+	r.mu.Unlock()
+	claimed.placing.Unlock()
+}
+
+// replace ends a replacing claim once entry has taken the place of the previous original.
+func (r *registry) replace(entry PlanEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	claimed := r.originals[entry.Checksum]
+	claimed.path = entry.Destination
+	claimed.entry = entry
+	claimed.placing.Unlock()
+}
+
+// release gives up the claim on checksum after the original could not be written, so that
+// the next file with the same content becomes the original instead.
+func (r *registry) release(checksum string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	claimed := r.originals[checksum]
+	delete(r.reserved, claimed.path)
+	claimed.path = ""
+	claimed.entry = PlanEntry{}
+	claimed.placing.Unlock()
+}
+
+// reserve returns a destination path that neither exists on disk nor has been handed out
+// during this run, and marks it as taken.
+func (r *registry) reserve(path string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reserveLocked(path)
+}
+
+// reserveLocked implements reserve. The caller must hold r.mu.
+func (r *registry) reserveLocked(path string) string {
+	if r.plan != nil {
+		return r.plan.reserve(path)
+	}
+
+	candidate := path
+	for i := 1; r.taken(candidate); i++ {
+		candidate = numberedPath(path, i)
+	}
+	r.reserved[candidate] = struct{}{}
+	return candidate
+}
+
+// taken reports whether a path is already in use. The caller must hold r.mu.
+func (r *registry) taken(path string) bool {
+	if _, ok := r.reserved[path]; ok {
+		return true
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,176 @@
+package photo
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRegistryClaim tests that exactly one concurrent claim on a checksum becomes the original
+func TestRegistryClaim(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-registry-claim")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	registry := newRegistry(nil, nil, nil)
+	dest := filepath.Join(tempDir, "photo.jpg")
+
+	const claimants = 32
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var originals []string
+	var duplicateOf []string
+	start := make(chan struct{})
+	for i := 0; i < claimants; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			reserved, original, outcome := registry.claim(PlanEntry{Checksum: "abc", Destination: dest}, nil)
+			mu.Lock()
+			defer mu.Unlock()
+			if outcome == claimDuplicate {
+				duplicateOf = append(duplicateOf, original.Destination)
+			} else {
+				originals = append(originals, reserved)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if len(originals) != 1 || originals[0] != dest {
+		t.Fatalf("Expected exactly one original at %s, got %v", dest, originals)
+	}
+	for _, path := range duplicateOf {
+		if path != dest {
+			t.Errorf("Expected duplicates to refer to %s, got %s", dest, path)
+		}
+	}
+
+	// A released claim is taken over by the next file with the same content
+	registry.release("abc")
+	if reserved, _, outcome := registry.claim(PlanEntry{Checksum: "abc", Destination: dest}, nil); outcome != claimOriginal || reserved != dest {
+		t.Errorf("Expected the released claim to be taken over at %s, got %q (outcome %v)", dest, reserved, outcome)
+	}
+	registry.settle("abc")
+
+	// Destinations are never handed out twice, even before anything is written
+	other := filepath.Join(tempDir, "other.jpg")
+	if first, second := registry.reserve(other), registry.reserve(other); first == second {
+		t.Errorf("Expected distinct reservations, got %s twice", first)
+	}
+}
+
+// TestProcessFileConcurrentDuplicates tests that identical files processed at the same time yield one original and one duplicate
+func TestProcessFileConcurrentDuplicates(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-file-concurrent")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	content := make([]byte, 256*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	// Repeat the race a few times, it is decided differently from run to run
+	for run := 0; run < 20; run++ {
+		runDir := filepath.Join(tempDir, fmt.Sprintf("run%d", run))
+		srcDir := filepath.Join(runDir, "src")
+		destDir := filepath.Join(runDir, "dest")
+		duplicatesDir := filepath.Join(destDir, "duplicates")
+		noDataDir := filepath.Join(destDir, "nodata")
+		for _, dir := range []string{srcDir, duplicatesDir, noDataDir} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatalf("Failed to create directory %s: %v", dir, err)
+			}
+		}
+		sources := []string{filepath.Join(srcDir, "first.bin"), filepath.Join(srcDir, "second.bin")}
+		for _, path := range sources {
+			if err := os.WriteFile(path, content, 0644); err != nil {
+				t.Fatalf("Failed to create test file: %v", err)
+			}
+		}
+
+		state := NewState(len(sources))
+		registry := newRegistry(nil, nil, nil)
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for _, path := range sources {
+			wg.Add(1)
+			go func(path string) {
+				defer wg.Done()
+				<-start
+				if err := processFile(path, true, destDir, duplicatesDir, noDataDir, registry, io.Discard, state, Options{}); err != nil {
+					t.Errorf("processFile returned an error: %v", err)
+				}
+			}(path)
+		}
+		close(start)
+		wg.Wait()
+
+		if state.GetNoDataCount() != 1 || state.GetDuplicateCount() != 1 {
+			t.Fatalf("Expected 1 original and 1 duplicate, got %d and %d", state.GetNoDataCount(), state.GetDuplicateCount())
+		}
+		originals, _ := os.ReadDir(noDataDir)
+		duplicates, _ := os.ReadDir(duplicatesDir)
+		if len(originals) != 1 || len(duplicates) != 1 {
+			t.Fatalf("Expected 1 file in nodata/ and 1 in duplicates/, got %d and %d", len(originals), len(duplicates))
+		}
+		if originals[0].Name() == duplicates[0].Name() {
+			t.Errorf("Expected the original and the duplicate to come from different sources, both are %s", originals[0].Name())
+		}
+	}
+}
+
+// TestProcessFileOriginalFailed tests that a duplicate waiting for an original that fails to be placed becomes the original
+func TestProcessFileOriginalFailed(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-original-failed")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	destDir := filepath.Join(tempDir, "dest")
+	createLibrary(t, srcDir, map[string]string{"first.txt": "Shared content", "second.txt": "Shared content"})
+	noDataDir := filepath.Join(destDir, "nodata")
+
+	// The first file claims the checksum, but its copy will fail
+	registry := newRegistry(nil, nil, nil)
+	first, err := inspectFile(filepath.Join(srcDir, "first.txt"), true, destDir, noDataDir, nil, HashMD5, false)
+	if err != nil {
+		t.Fatalf("inspectFile returned an error: %v", err)
+	}
+	if _, _, outcome := registry.claim(first, nil); outcome != claimOriginal {
+		t.Fatalf("Expected the first file to become the original, got outcome %v", outcome)
+	}
+
+	// A linked duplicate waits for its original to be placed
+	state := NewState(1)
+	done := make(chan error, 1)
+	go func() {
+		second := filepath.Join(srcDir, "second.txt")
+		done <- processFile(second, true, destDir, filepath.Join(destDir, "duplicates"), noDataDir, registry, io.Discard, state, Options{Duplicates: LinkHardlink})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	registry.release(first.Checksum)
+
+	if err := <-done; err != nil {
+		t.Fatalf("processFile returned an error: %v", err)
+	}
+	if state.GetNoDataCount() != 1 || state.GetDuplicateCount() != 0 {
+		t.Errorf("Expected the second file to be organized, got %d no-data files and %d duplicates", state.GetNoDataCount(), state.GetDuplicateCount())
+	}
+	if placed, ok := registry.placed(first.Checksum); !ok || filepath.Base(placed.Source) != "second.txt" {
+		t.Errorf("Expected second.txt to be the original, got %+v", placed)
+	}
+}
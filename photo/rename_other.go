@@ -0,0 +1,11 @@
+//go:build !linux
+
+package photo
+
+import "errors"
+
+// renameExclusive returns errors.ErrUnsupported, as renames that refuse to replace are only
+// made by the kernel on Linux.
+func renameExclusive(oldPath, newPath string) error {
+	return errors.ErrUnsupported
+}
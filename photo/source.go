@@ -0,0 +1,108 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"time"
+)
+
+// maxRetainedHead is the most bytes of a source kept in memory while its creation date is
+// extracted. EXIF data usually ends within the first few kilobytes, but the decoder reads the
+// whole file while searching for EXIF data that is not there.
+const maxRetainedHead = 1 << 20
+
+// sourceFile is a source file that is read only once for its creation date, its checksum and,
+// when it cannot have a duplicate, its copy. Everything read while extracting the date is fed
+// to the hash, so the checksum continues where the extraction stopped instead of starting over.
+// A source opened for copying also retains what was read, up to maxRetainedHead bytes.
+type sourceFile struct {
+	file    *os.File
+	hash    hash.Hash  // Everything read from file so far, in order
+	head    headBuffer // Everything read from file so far, if copying
+	copying bool
+}
+
+// headBuffer keeps what is written to it as long as it stays within maxRetainedHead bytes.
+type headBuffer struct {
+	bytes.Buffer
+	overflow bool // More was written than could be kept
+}
+
+// Write keeps p unless the buffer overflows; it never fails.
+func (h *headBuffer) Write(p []byte) (int, error) {
+	if !h.overflow && h.Len()+len(p) > maxRetainedHead {
+		h.overflow = true
+		h.Reset()
+	}
+	if !h.overflow {
+		h.Buffer.Write(p)
+	}
+	return len(p), nil
+}
+
+// openSource opens a source file for reading, to be hashed with hasher. If copying is set, the
+// file is going to be copied with copyTo.
+func openSource(path string, hasher Hasher, copying bool) (*sourceFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &sourceFile{file: file, hash: hasher.New(), copying: copying}, nil
+}
+
+// photoDate extracts the EXIF creation date from the start of the file. It must be called
+// before checksum and copyTo.
+func (s *sourceFile) photoDate() time.Time {
+	return getPhotoCreationDate(s.reader(), time.Time{})
+}
+
+// reader returns a reader of the file that feeds everything read to the hash, and to the head
+// if copying, so that checksum and copyTo continue where it stopped.
+func (s *sourceFile) reader() io.Reader {
+	var consumed io.Writer = s.hash
+	if s.copying {
+		consumed = io.MultiWriter(s.hash, &s.head)
+	}
+	return io.TeeReader(s.file, consumed)
+}
+
+// checksum reads the rest of the file and returns the checksum of its whole content.
+func (s *sourceFile) checksum() (string, error) {
+	if _, err := io.Copy(s.hash, s.file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(s.hash.Sum(nil)), nil
+}
+
+// copyTo copies the whole file to dest and returns its checksum. What was read for the date is
+// written from memory and the rest is hashed on its way to dest. If more was read than could be
+// retained, the kernel copies the whole file instead, and only the rest is read to hash it.
+func (s *sourceFile) copyTo(dest *os.File) (string, error) {
+	if s.copying && !s.head.overflow {
+		if _, err := dest.Write(s.head.Bytes()); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(io.MultiWriter(dest, s.hash), s.file); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(s.hash.Sum(nil)), nil
+	}
+
+	again, err := os.Open(s.file.Name())
+	if err != nil {
+		return "", err
+	}
+	defer again.Close()
+	if _, err := io.Copy(dest, again); err != nil {
+		return "", err
+	}
+	return s.checksum()
+}
+
+// Close closes the file.
+func (s *sourceFile) Close() error {
+	return s.file.Close()
+}
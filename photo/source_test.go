@@ -0,0 +1,234 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exifTIFF returns the TIFF structure of EXIF data that records dateTime ("2006:01:02 15:04:05")
+func exifTIFF(dateTime string) []byte {
+	// A little-endian TIFF structure with a single DateTime tag in IFD0
+	var tiff bytes.Buffer
+	tiff.WriteString("II*\x00")
+	binary.Write(&tiff, binary.LittleEndian, uint32(8))        // Offset of IFD0
+	binary.Write(&tiff, binary.LittleEndian, uint16(1))        // Number of entries
+	binary.Write(&tiff, binary.LittleEndian, uint16(0x0132))   // DateTime
+	binary.Write(&tiff, binary.LittleEndian, uint16(2))        // ASCII
+	binary.Write(&tiff, binary.LittleEndian, uint32(20))       // Length including the terminator
+	binary.Write(&tiff, binary.LittleEndian, uint32(8+2+12+4)) // Offset of the value
+	binary.Write(&tiff, binary.LittleEndian, uint32(0))        // No next IFD
+	tiff.WriteString(dateTime + "\x00")
+	return tiff.Bytes()
+}
+
+// exifJPEG returns a JPEG whose EXIF data records dateTime ("2006:01:02 15:04:05"), followed by
+// size bytes of image data
+func exifJPEG(dateTime string, size int) []byte {
+	tiff := exifTIFF(dateTime)
+	var jpeg bytes.Buffer
+	jpeg.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(&jpeg, binary.BigEndian, uint16(2+6+len(tiff)))
+	jpeg.WriteString("Exif\x00\x00")
+	jpeg.Write(tiff)
+	jpeg.Write(bytes.Repeat([]byte{0x42}, size))
+	jpeg.Write([]byte{0xFF, 0xD9})
+	return jpeg.Bytes()
+}
+
+// TestInspectFileSinglePass tests that the date and checksum taken in one pass match the file
+func TestInspectFileSinglePass(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-inspect-single-pass")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	tests := []struct {
+		name    string
+		content string
+		dated   bool
+	}{
+		{"exif.jpg", string(exifJPEG("2015:01:02 03:04:05", 64*1024)), true},
+		{"small.png", "No EXIF data in here", false},
+		// The decoder reads all of it while searching for EXIF data, so the head is read again
+		{"large.png", strings.Repeat("No EXIF data. ", 2*maxRetainedHead/10), false},
+	}
+	destDir := filepath.Join(tempDir, "dest")
+	for _, test := range tests {
+		srcPath := filepath.Join(tempDir, test.name)
+		if err := os.WriteFile(srcPath, []byte(test.content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+
+		entry, err := inspectFile(srcPath, true, destDir, filepath.Join(destDir, "nodata"), nil, HashMD5, false)
+		if err != nil {
+			t.Fatalf("%s: inspectFile returned an error: %v", test.name, err)
+		}
+		if entry.Checksum != md5Hex(test.content) {
+			t.Errorf("%s: Expected checksum %s, got %s", test.name, md5Hex(test.content), entry.Checksum)
+		}
+		if (entry.Action == ActionOrganize) != test.dated {
+			t.Errorf("%s: Expected dated = %v, got action %s", test.name, test.dated, entry.Action)
+		}
+		if test.dated && !strings.HasPrefix(entry.Destination, filepath.Join(destDir, "2015", "01", "02")) {
+			t.Errorf("%s: Expected a destination for 2015-01-02, got %s", test.name, entry.Destination)
+		}
+	}
+}
+
+// TestSourceFileCopyTo tests that a source copied after its date was read is copied and hashed in full
+func TestSourceFileCopyTo(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-source-copy")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Beyond maxRetainedHead the head is copied again instead of from memory
+	for _, size := range []int{0, 1024, 2 * maxRetainedHead} {
+		content := exifJPEG("2020:05:06 07:08:09", size)
+		srcPath := filepath.Join(tempDir, "photo.jpg")
+		if err := os.WriteFile(srcPath, content, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+
+		for _, copying := range []bool{true, false} {
+			source, err := openSource(srcPath, HashMD5, copying)
+			if err != nil {
+				t.Fatalf("openSource returned an error: %v", err)
+			}
+			if date := source.photoDate(); date.IsZero() {
+				t.Errorf("%d bytes: Expected a date", size)
+			}
+			dest, err := os.Create(filepath.Join(tempDir, "copy.jpg"))
+			if err != nil {
+				t.Fatalf("Failed to create copy: %v", err)
+			}
+			checksum, err := source.copyTo(dest)
+			dest.Close()
+			source.Close()
+			if err != nil {
+				t.Fatalf("copyTo returned an error: %v", err)
+			}
+
+			if checksum != md5Hex(string(content)) {
+				t.Errorf("%d bytes, copying %v: Expected checksum %s, got %s", size, copying, md5Hex(string(content)), checksum)
+			}
+			if copied, err := os.ReadFile(dest.Name()); err != nil || !bytes.Equal(copied, content) {
+				t.Errorf("%d bytes, copying %v: Expected the whole file, got %d bytes, %v", size, copying, len(copied), err)
+			}
+		}
+	}
+}
+
+// readBytes returns the number of bytes this process has read so far, or false where the
+// system does not report it
+func readBytes() (int64, bool) {
+	stats, err := os.ReadFile("/proc/self/io")
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(stats), "\n") {
+		if value, found := strings.CutPrefix(line, "rchar: "); found {
+			n, err := strconv.ParseInt(value, 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// benchmarkReads runs organize on a fresh copy of content for every iteration and reports the
+// bytes read per file as read-B/op
+func benchmarkReads(b *testing.B, content []byte, organize func(src, destDir string) error) {
+	tempDir, err := os.MkdirTemp("", "bench-reads")
+	if err != nil {
+		b.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcPath := filepath.Join(tempDir, "photo.jpg")
+	if err := os.WriteFile(srcPath, content, 0644); err != nil {
+		b.Fatalf("Failed to create test file: %v", err)
+	}
+	destDir := filepath.Join(tempDir, "dest")
+
+	var read int64
+	measured := true
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		before, ok := readBytes()
+		if err := organize(srcPath, destDir); err != nil {
+			b.Fatalf("Failed to organize file: %v", err)
+		}
+		after, _ := readBytes()
+		read += after - before
+		measured = measured && ok
+
+		b.StopTimer()
+		os.RemoveAll(destDir)
+		b.StartTimer()
+	}
+	if measured {
+		b.ReportMetric(float64(read)/float64(b.N), "read-B/op")
+	}
+}
+
+// BenchmarkFileReads compares the bytes read to organize a 4 MiB file, as counted by the
+// system, which includes the bytes the kernel copies between files. The baseline inspects and
+// copies it the way earlier versions did: the EXIF decoder, the checksum and the copy each read
+// the file. Hashed files are read once for the date and checksum and then copied by the kernel;
+// files that cannot have a duplicate are copied from that same pass. A file without EXIF data is
+// read in full by the decoder, which the baseline pays for with an extra pass.
+func BenchmarkFileReads(b *testing.B) {
+	fixtures := []struct {
+		name    string
+		content []byte
+	}{
+		{"exif", exifJPEG("2015:01:02 03:04:05", 4<<20)},
+		{"no-exif", bytes.Repeat([]byte("No EXIF data. "), (4<<20)/14)},
+	}
+	process := func(hash bool) func(src, destDir string) error {
+		return func(src, destDir string) error {
+			registry := newRegistry(nil, nil, nil)
+			return processFile(src, hash, destDir, filepath.Join(destDir, "duplicates"), filepath.Join(destDir, "nodata"), registry, io.Discard, NewState(1), Options{})
+		}
+	}
+	baseline := func(src, destDir string) error {
+		file, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		getPhotoCreationDate(file, time.Time{})
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := calculateChecksum(file, HashMD5); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
+			return err
+		}
+		return copyFile(src, filepath.Join(destDir, "photo.jpg"), Preserve{})
+	}
+
+	for _, fixture := range fixtures {
+		b.Run(fixture.name+"/baseline", func(b *testing.B) {
+			benchmarkReads(b, fixture.content, baseline)
+		})
+		b.Run(fixture.name+"/hashed", func(b *testing.B) {
+			benchmarkReads(b, fixture.content, process(true))
+		})
+		b.Run(fixture.name+"/unhashed", func(b *testing.B) {
+			benchmarkReads(b, fixture.content, process(false))
+		})
+	}
+}
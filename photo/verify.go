@@ -0,0 +1,93 @@
+package photo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// compareBlockSize is the number of bytes read from each file at a time by sameContent.
+const compareBlockSize = 64 * 1024
+
+// sameContent reports whether two files have identical content. Files that cannot be read are
+// reported as different, so that a file is never treated as a duplicate without proof.
+func sameContent(a, b string) bool {
+	fileA, err := os.Open(a)
+	if err != nil {
+		return false
+	}
+	defer fileA.Close()
+	fileB, err := os.Open(b)
+	if err != nil {
+		return false
+	}
+	defer fileB.Close()
+
+	infoA, errA := fileA.Stat()
+	infoB, errB := fileB.Stat()
+	if errA != nil || errB != nil || infoA.Size() != infoB.Size() {
+		return false
+	}
+
+	bufA := make([]byte, compareBlockSize)
+	bufB := make([]byte, compareBlockSize)
+	for {
+		n, errA := io.ReadFull(fileA, bufA)
+		m, errB := io.ReadFull(fileB, bufB)
+		if n != m || !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == errA
+		}
+		if errA != nil || errB != nil {
+			return false
+		}
+	}
+}
+
+// verifyDuplicate compares a file whose checksum matches an original with that original byte
+// for byte. During a dry run the originals of this run have not been written yet, so they are
+// compared at their source.
+func verifyDuplicate(entry, original PlanEntry, options Options) bool {
+	reference := original.Destination
+	if options.Plan != nil && original.Source != "" {
+		reference = original.Source
+	}
+	return sameContent(entry.Source, reference)
+}
+
+// placeCollision organizes a file that has the checksum of originalPath but different content
+// at its reserved destination. It does not become the original for its checksum.
+func placeCollision(entry PlanEntry, originalPath string, logFile io.Writer, state *State, options Options) error {
+	entry.CollidesWith = originalPath
+
+	if options.Plan != nil {
+		options.Plan.add(entry)
+		state.AddPlannedBytes(options.Plan.writtenBytes(entry))
+	} else {
+		placedPath, err := placeOriginal(entry, options)
+		if err != nil && !errors.Is(err, errSourceNotRemoved) {
+			return err
+		}
+		entry.Destination = placedPath
+		if err := recordOriginal(entry, err, options); err != nil {
+			return err
+		}
+	}
+
+	if entry.Action == ActionNoData {
+		state.IncrementNoData()
+	} else {
+		state.IncrementUnique()
+	}
+	logCollision(logFile, entry)
+	return nil
+}
+
+// logCollision logs a file that was organized as unique despite a checksum match.
+func logCollision(logFile io.Writer, entry PlanEntry) {
+	_, _ = fmt.Fprintf(logFile, "Hash collision: %s (same checksum as: %s, organized as: %s)\n", entry.Source, entry.CollidesWith, entry.Destination)
+}
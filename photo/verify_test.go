@@ -0,0 +1,163 @@
+package photo
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSameContent tests the byte-for-byte comparison of files
+func TestSameContent(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-same-content")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Several blocks, so a difference near the end is only seen by reading everything
+	content := strings.Repeat("0123456789", compareBlockSize/4)
+	changed := content[:len(content)-1] + "x"
+	createLibrary(t, tempDir, map[string]string{
+		"a.jpg":       content,
+		"b.jpg":       content,
+		"changed.jpg": changed,
+		"shorter.jpg": content[:len(content)-1],
+		"empty1.jpg":  "",
+		"empty2.jpg":  "",
+	})
+
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"a.jpg", "b.jpg", true},
+		{"a.jpg", "changed.jpg", false},
+		{"a.jpg", "shorter.jpg", false},
+		{"empty1.jpg", "empty2.jpg", true},
+		{"a.jpg", "missing.jpg", false},
+	}
+	for _, test := range tests {
+		if result := sameContent(filepath.Join(tempDir, test.a), filepath.Join(tempDir, test.b)); result != test.expected {
+			t.Errorf("sameContent(%s, %s) = %v, expected %v", test.a, test.b, result, test.expected)
+		}
+	}
+}
+
+// TestProcessFileParanoid tests that a checksum match with different content is organized as unique
+func TestProcessFileParanoid(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-process-file-paranoid")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	destDir := filepath.Join(tempDir, "dest")
+	duplicatesDir := filepath.Join(destDir, "duplicates")
+	noDataDir := filepath.Join(destDir, "nodata")
+	content := "Family photo"
+	createLibrary(t, tempDir, map[string]string{
+		"photo.jpg":                                  content,
+		filepath.Join("dest", "same.jpg"):            content,
+		filepath.Join("dest", "collide.jpg"):         "Different content with the same checksum",
+		filepath.Join("dest", "duplicates", ".keep"): "",
+		filepath.Join("dest", "nodata", ".keep"):     "",
+	})
+	source := filepath.Join(tempDir, "photo.jpg")
+	checksum := md5Hex(content)
+
+	tests := []struct {
+		name      string
+		original  string
+		paranoid  bool
+		duplicate bool
+	}{
+		{"unverified", "collide.jpg", false, true},
+		{"identical", "same.jpg", true, true},
+		{"collision", "collide.jpg", true, false},
+	}
+	for _, test := range tests {
+		// A previous run organized the original, as recorded in the index
+		original := filepath.Join(destDir, test.original)
+		registry := newRegistry(map[string]string{checksum: original}, nil, nil)
+		state := NewState(1)
+		var log bytes.Buffer
+
+		err := processFile(source, true, destDir, duplicatesDir, noDataDir, registry, &log, state, Options{Paranoid: test.paranoid})
+		if err != nil {
+			t.Fatalf("%s: processFile returned an error: %v", test.name, err)
+		}
+
+		if test.duplicate {
+			if state.GetDuplicateCount() != 1 || !strings.Contains(log.String(), "Duplicate detected") {
+				t.Errorf("%s: Expected a duplicate, got %d duplicates and log %q", test.name, state.GetDuplicateCount(), log.String())
+			}
+			continue
+		}
+		if state.GetDuplicateCount() != 0 || state.GetNoDataCount() != 1 {
+			t.Errorf("%s: Expected a unique file, got %d duplicates and %d no-data files", test.name, state.GetDuplicateCount(), state.GetNoDataCount())
+		}
+		if !strings.Contains(log.String(), "Hash collision: "+source) {
+			t.Errorf("%s: Expected the collision to be logged, got %q", test.name, log.String())
+		}
+		copied, err := os.ReadFile(filepath.Join(noDataDir, "photo.jpg"))
+		if err != nil || string(copied) != content {
+			t.Errorf("%s: Expected the file to be organized, got %q, %v", test.name, copied, err)
+		}
+		// The original keeps its checksum
+		if placed, _ := registry.placed(checksum); placed.Destination != original {
+			t.Errorf("%s: Expected the original to stay %s, got %s", test.name, original, placed.Destination)
+		}
+	}
+}
+
+// TestChooseKeepersParanoid tests that deterministic runs also organize checksum collisions as unique
+func TestChooseKeepersParanoid(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test-choose-keepers-paranoid")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	createLibrary(t, tempDir, map[string]string{
+		"a.jpg": "Content A",
+		"b.jpg": "Content A",
+		"c.jpg": "Content C",
+	})
+	newEntries := func() []PlanEntry {
+		var entries []PlanEntry
+		for _, name := range []string{"c.jpg", "b.jpg", "a.jpg"} {
+			entries = append(entries, PlanEntry{
+				Source:      filepath.Join(tempDir, name),
+				Action:      ActionNoData,
+				Destination: filepath.Join(tempDir, "dest", "nodata", name),
+				Checksum:    "same", // As if all three collided
+			})
+		}
+		return entries
+	}
+
+	entries := chooseKeepers(newEntries(), filepath.Join(tempDir, "dest", "duplicates"), newRegistry(nil, nil, nil), KeeperPolicy{}, true)
+	actions := make(map[string]PlanEntry)
+	for _, entry := range entries {
+		actions[filepath.Base(entry.Source)] = entry
+	}
+	if actions["a.jpg"].Action != ActionNoData || actions["b.jpg"].Action != ActionDuplicate || actions["c.jpg"].Action != ActionNoData {
+		t.Errorf("Expected a and c to be organized and b to be a duplicate, got %s, %s and %s", actions["a.jpg"].Action, actions["b.jpg"].Action, actions["c.jpg"].Action)
+	}
+	if actions["c.jpg"].CollidesWith != actions["a.jpg"].Destination {
+		t.Errorf("Expected c to collide with %s, got %q", actions["a.jpg"].Destination, actions["c.jpg"].CollidesWith)
+	}
+	if actions["c.jpg"].Destination == actions["a.jpg"].Destination {
+		t.Errorf("Expected distinct destinations, got %s twice", actions["c.jpg"].Destination)
+	}
+
+	// Without verification the checksum decides
+	entries = chooseKeepers(newEntries(), filepath.Join(tempDir, "dest", "duplicates"), newRegistry(nil, nil, nil), KeeperPolicy{}, false)
+	for _, entry := range entries {
+		if filepath.Base(entry.Source) == "c.jpg" && entry.Action != ActionDuplicate {
+			t.Errorf("Expected c to be a duplicate without verification, got %s", entry.Action)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// getWebPCreationDate extracts the creation date of a WebP image of the given size. Extended
+// WebP files keep their metadata in the EXIF and "XMP " chunks of their RIFF container; the EXIF
+// data is preferred, and the source of the date found is returned with it. The image data
+// chunks are skipped unread.
+func getWebPCreationDate(r io.ReaderAt, size int64) (time.Time, string) {
+	var header [12]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return time.Time{}, DateSourceNone
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return time.Time{}, DateSourceNone
+	}
+	end := min(8+int64(binary.LittleEndian.Uint32(header[4:8])), size)
+
+	var exifDate, xmpDate time.Time
+	for offset := int64(len(header)); offset+8 <= end; {
+		var chunk [8]byte
+		if _, err := r.ReadAt(chunk[:], offset); err != nil {
+			break
+		}
+		id := string(chunk[:4])
+		length := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		if length > end-offset-8 {
+			break
+		}
+
+		if (id == "EXIF" || id == "XMP ") && length <= maxMetadataBox {
+			data := make([]byte, length)
+			if _, err := r.ReadAt(data, offset+8); err != nil {
+				break
+			}
+			// The EXIF chunk starts with the TIFF header, or with "Exif\0\0" as some tools write it
+			if id == "EXIF" && exifDate.IsZero() {
+				exifDate = getPhotoCreationDate(bytes.NewReader(data), time.Time{})
+			} else if id == "XMP " && xmpDate.IsZero() {
+				xmpDate = getXMPCreationDate(data)
+			}
+		}
+		offset += 8 + length + length%2 // Chunks are padded to an even size
+	}
+
+	if !exifDate.IsZero() {
+		return exifDate, DateSourceExif
+	}
+	if !xmpDate.IsZero() {
+		return xmpDate, DateSourceXMP
+	}
+	return time.Time{}, DateSourceNone
+}
@@ -0,0 +1,65 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// webpChunk returns a RIFF chunk of a WebP image, padded to an even size
+func webpChunk(id string, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(id)
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	if len(data)%2 == 1 {
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+// webpFile returns an extended WebP image with the given metadata chunks after its image data
+func webpFile(chunks ...[]byte) []byte {
+	body := bytes.Join(append([][]byte{
+		[]byte("WEBP"),
+		webpChunk("VP8X", make([]byte, 10)),
+		webpChunk("VP8 ", bytes.Repeat([]byte{0x42}, 2047)),
+	}, chunks...), nil)
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(len(body)))
+	b.Write(body)
+	return b.Bytes()
+}
+
+// TestGetWebPCreationDate tests the dates read from the EXIF and XMP chunks of WebP images
+func TestGetWebPCreationDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  []byte
+		expected string
+		source   string
+	}{
+		{"EXIF", webpFile(webpChunk("EXIF", exifTIFF("2020:05:06 07:08:09"))), "2020-05-06 07:08:09", DateSourceExif},
+		{"EXIF with its JPEG prefix", webpFile(webpChunk("EXIF", append([]byte("Exif\x00\x00"), exifTIFF("2020:05:06 07:08:09")...))), "2020-05-06 07:08:09", DateSourceExif},
+		{"XMP", webpFile(webpChunk("XMP ", xmpPacket(`xmp:CreateDate="2020-05-06T07:08:09"/>`))), "2020-05-06 07:08:09", DateSourceXMP},
+		{"EXIF before XMP", webpFile(webpChunk("XMP ", xmpPacket(`xmp:CreateDate="2021-01-01T00:00:00"/>`)), webpChunk("EXIF", exifTIFF("2020:05:06 07:08:09"))), "2020-05-06 07:08:09", DateSourceExif},
+		{"No metadata", webpFile(), "", DateSourceNone},
+		{"Not a WebP image", []byte("This is not a valid image file"), "", DateSourceNone},
+	}
+
+	for _, test := range tests {
+		date, source := getWebPCreationDate(bytes.NewReader(test.content), int64(len(test.content)))
+		got := ""
+		if !date.IsZero() {
+			got = date.Format(time.DateTime)
+		}
+		if got != test.expected {
+			t.Errorf("%s: Expected %q, got %q", test.name, test.expected, got)
+		}
+		if source != test.source {
+			t.Errorf("%s: Expected date source %q, got %q", test.name, test.source, source)
+		}
+	}
+}
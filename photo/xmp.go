@@ -0,0 +1,89 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"time"
+)
+
+// xmpDateProperties are the XMP properties that date a photo, most specific first: when it was
+// taken, when its content was created, and when the file itself was created.
+var xmpDateProperties = []xml.Name{
+	{Space: "http://ns.adobe.com/exif/1.0/", Local: "DateTimeOriginal"},
+	{Space: "http://ns.adobe.com/photoshop/1.0/", Local: "DateCreated"},
+	{Space: "http://ns.adobe.com/xap/1.0/", Local: "CreateDate"},
+}
+
+// xmpDateLayouts are the forms of the ISO 8601 dates of XMP that name a day. The time zone is
+// optional, and dates without one are local to where the photo was taken, as in EXIF.
+var xmpDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// getXMPCreationDate extracts the creation date of a photo from an XMP packet. Properties are
+// matched by their namespace, whatever prefix the packet binds it to, and may be written as
+// attributes of a description or as elements of their own.
+func getXMPCreationDate(packet []byte) time.Time {
+	values := make(map[xml.Name]string)
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	decoder.Strict = false
+	var property xml.Name // The date property whose element is open, if any
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break // The end of the packet, or the padding or trailer after it
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			property = xml.Name{}
+			for _, attr := range t.Attr {
+				if isXMPDateProperty(attr.Name) && values[attr.Name] == "" {
+					values[attr.Name] = attr.Value
+				}
+			}
+			if isXMPDateProperty(t.Name) {
+				property = t.Name
+			}
+		case xml.CharData:
+			if property.Local != "" && values[property] == "" {
+				values[property] = strings.TrimSpace(string(t))
+			}
+		case xml.EndElement:
+			property = xml.Name{}
+		}
+	}
+
+	for _, name := range xmpDateProperties {
+		if date, ok := parseXMPDate(values[name]); ok {
+			return date
+		}
+	}
+	return time.Time{}
+}
+
+// isXMPDateProperty reports whether name is one of xmpDateProperties.
+func isXMPDateProperty(name xml.Name) bool {
+	for _, property := range xmpDateProperties {
+		if name == property {
+			return true
+		}
+	}
+	return false
+}
+
+// parseXMPDate parses an XMP date. Dates that only name a year or a month cannot place a photo
+// in a day directory and are rejected.
+func parseXMPDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range xmpDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
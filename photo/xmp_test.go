@@ -0,0 +1,54 @@
+package photo
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// xmpPacket returns an XMP packet with the given properties of the description, which are
+// written as they would be in its body
+func xmpPacket(properties string) []byte {
+	return []byte(fmt.Sprintf(`<?xpacket begin="`+"\ufeff"+`" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:ps="http://ns.adobe.com/photoshop/1.0/"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    %s
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`, properties))
+}
+
+// TestGetXMPCreationDate tests the dates read from XMP packets, in attributes and elements
+func TestGetXMPCreationDate(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties string
+		expected   string
+	}{
+		{"CreateDate attribute", `xmp:CreateDate="2020-03-04T05:06:07"/>`, "2020-03-04 05:06:07"},
+		{"DateCreated element", `><ps:DateCreated>2020-03-04T05:06:07.250+02:00</ps:DateCreated></rdf:Description>`, "2020-03-04 05:06:07"},
+		{"Taken before created", `xmp:CreateDate="2021-01-01T00:00:00Z"><exif:DateTimeOriginal>2020-03-04T05:06</exif:DateTimeOriginal></rdf:Description>`, "2020-03-04 05:06:00"},
+		{"Day only", `xmp:CreateDate="2020-03-04"/>`, "2020-03-04 00:00:00"},
+		{"Month only", `xmp:CreateDate="2020-03"/>`, ""},
+		{"Unrelated date", `xmp:ModifyDate="2020-03-04T05:06:07"/>`, ""},
+	}
+
+	for _, test := range tests {
+		date := getXMPCreationDate(xmpPacket(test.properties))
+		got := ""
+		if !date.IsZero() {
+			got = date.Format(time.DateTime)
+		}
+		if got != test.expected {
+			t.Errorf("%s: Expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+
+	if date := getXMPCreationDate([]byte("This is not XMP")); !date.IsZero() {
+		t.Errorf("Expected no date for text that is not XMP, got %v", date)
+	}
+}
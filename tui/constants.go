@@ -36,6 +36,9 @@ var (
 			Foreground(colorLabel).
 			Align(lipgloss.Right).
 			Padding(0, padding)
+	messageStyle = lipgloss.NewStyle().
+			Foreground(colorLabel).
+			Align(lipgloss.Center)
 	numberStyle = lipgloss.NewStyle().
 			Bold(true).
 			Foreground(colorNumber).
@@ -5,6 +5,7 @@ import (
 	"github.com/charmbracelet/bubbles/progress"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"sort"
 	"strings"
 )
 
@@ -20,14 +21,33 @@ type ProgressProvider interface {
 	GetErrorCount() int
 	GetUniqueFileCount() int
 	GetNoDataCount() int // Returns a copy of the current state
+	GetSkippedCount() int
+	GetLibraryCount() int
+	GetSizeUniqueCount() int
+	GetHeadUniqueCount() int
+	GetFullHashCount() int
+	GetRawFormatCounts() map[string]int
+	IsDryRun() bool
+	GetPlannedBytes() int64
 	GetMessage() string
 	UpdateMessage(string)
 }
 
+// Pauser is implemented by progress providers whose background work can be paused.
+type Pauser interface {
+	Pause()
+	Resume()
+	IsPaused() bool
+}
+
 // ProgressUpdateMsg is a generic message to signal the TUI to update its progress.
 // This decouples the TUI from specific messages from other packages.
 type ProgressUpdateMsg struct{}
 
+// ProcessingDoneMsg signals that the background work has finished, either because it completed
+// or because it was cancelled and all workers have drained.
+type ProcessingDoneMsg struct{}
+
 type HeaderProps struct {
 	Heading string
 	Width   int
@@ -38,6 +58,9 @@ type DupModel struct {
 	StatusProvider ProgressProvider
 	Progress       progress.Model
 	Quitting       bool
+	Cancelling     bool   // Set once the user asked to quit while work was still running
+	Cancel         func() // Stops the background work; if nil, quitting is immediate
+	done           bool
 	width          int
 }
 
@@ -62,9 +85,36 @@ func (m DupModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg: // Handle keypress events
 		if msg.String() == "q" || msg.String() == "ctrl+c" {
+			// Let the workers drain before quitting so no half-written file is left behind
+			if m.Cancel != nil && !m.done {
+				if !m.Cancelling {
+					m.Cancelling = true
+					m.Cancel()
+				}
+				return m, nil
+			}
+			m.Quitting = true
+			return m, tea.Quit
+		}
+
+		if msg.String() == "p" && !m.done && !m.Cancelling {
+			if pauser, ok := m.StatusProvider.(Pauser); ok {
+				if pauser.IsPaused() {
+					pauser.Resume()
+				} else {
+					pauser.Pause()
+				}
+			}
+			return m, nil
+		}
+
+	case ProcessingDoneMsg:
+		m.done = true
+		if m.Cancelling {
 			m.Quitting = true
 			return m, tea.Quit
 		}
+		return m, nil
 
 	// This is our new generic message case. It's triggered when the TUI needs to update its display.
 	case ProgressUpdateMsg:
@@ -105,8 +155,13 @@ func (m DupModel) View() string {
 	m.Progress.ShowPercentage = false
 	progressBar := m.Progress.ViewAs(progressRatio)
 
+	heading := "Photo Organizer"
+	if state.IsDryRun() {
+		heading = "Photo Organizer (Dry Run)"
+	}
+
 	RenderHeader(doc, HeaderProps{
-		Heading: "Photo Organizer",
+		Heading: heading,
 		Width:   m.width,
 	})
 	RenderStatsList(doc, state, m.width)
@@ -115,7 +170,21 @@ func (m DupModel) View() string {
 	doc.WriteString(progressStyle.Render(fmt.Sprintf("%s", progressBar)))
 	doc.WriteString("\n\n")
 
-	doc.WriteString(controlsStyle.Width(m.width).PaddingRight(padding).Render("Press ctrl+c or 'q' to quit."))
+	doc.WriteString(messageStyle.Width(m.width).Render(state.GetMessage()))
+	doc.WriteString("\n\n")
+
+	controls := "Press ctrl+c or 'q' to quit."
+	if pauser, ok := state.(Pauser); ok && !m.done {
+		if pauser.IsPaused() {
+			controls = "Press 'p' to resume, ctrl+c or 'q' to quit."
+		} else {
+			controls = "Press 'p' to pause, ctrl+c or 'q' to quit."
+		}
+	}
+	if m.Cancelling {
+		controls = "Cancelling… waiting for in-flight files to finish."
+	}
+	doc.WriteString(controlsStyle.Width(m.width).PaddingRight(padding).Render(controls))
 	doc.WriteString("\n")
 
 	return doc.String()
@@ -125,7 +194,12 @@ func RenderStatsList(doc *strings.Builder, progress ProgressProvider, width int)
 	cWidth := width / 2
 	label := labelStyle.Width(cWidth)
 	count := fmt.Sprintf("%d", progress.GetTotalCount())
-	number := numberStyle.Width(lipgloss.Width(count) + padding*2)
+	planned := formatBytes(progress.GetPlannedBytes())
+	numberWidth := lipgloss.Width(count)
+	if progress.IsDryRun() && lipgloss.Width(planned) > numberWidth {
+		numberWidth = lipgloss.Width(planned)
+	}
+	number := numberStyle.Width(numberWidth + padding*2)
 
 	rowLabels := []string{
 		label.Render("Total Files:"),
@@ -133,6 +207,7 @@ func RenderStatsList(doc *strings.Builder, progress ProgressProvider, width int)
 		label.Render("Unique Files:"),
 		label.Render("No Date:"),
 		label.Render("Duplicates:"),
+		label.Render("Skipped:"),
 		label.Render("Errors:"),
 	}
 
@@ -142,9 +217,48 @@ func RenderStatsList(doc *strings.Builder, progress ProgressProvider, width int)
 		number.Render(fmt.Sprintf("%d", progress.GetUniqueFileCount())),
 		number.Render(fmt.Sprintf("%d", progress.GetNoDataCount())),
 		number.Render(fmt.Sprintf("%d", progress.GetDuplicateCount())),
+		number.Render(fmt.Sprintf("%d", progress.GetSkippedCount())),
 		number.Render(fmt.Sprintf("%d", progress.GetErrorCount())),
 	}
 
+	// Imports into an existing library report its size
+	if library := progress.GetLibraryCount(); library > 0 {
+		rowLabels = append(rowLabels, label.Render("Library Files:"))
+		stats = append(stats, number.Render(fmt.Sprintf("%d", library)))
+	}
+
+	// Copying runs report how many files each stage of the duplicate check settled
+	if sized, heads := progress.GetSizeUniqueCount(), progress.GetHeadUniqueCount(); sized+heads > 0 {
+		rowLabels = append(rowLabels,
+			label.Render("Unique Size:"),
+			label.Render("Unique Head/Tail:"),
+			label.Render("Fully Hashed:"),
+		)
+		stats = append(stats,
+			number.Render(fmt.Sprintf("%d", sized)),
+			number.Render(fmt.Sprintf("%d", heads)),
+			number.Render(fmt.Sprintf("%d", progress.GetFullHashCount())),
+		)
+	}
+
+	// Camera RAW files are reported by format, so unrecognized formats stand out
+	rawCounts := progress.GetRawFormatCounts()
+	formats := make([]string, 0, len(rawCounts))
+	for format := range rawCounts {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	for _, format := range formats {
+		rowLabels = append(rowLabels, label.Render(fmt.Sprintf("RAW %s:", format)))
+		stats = append(stats, number.Render(fmt.Sprintf("%d", rawCounts[format])))
+	}
+
+	// A dry run also reports how much data the plan would write
+	if progress.IsDryRun() {
+		rowLabels = append(rowLabels, label.Render("Planned Size:"))
+		stats = append(stats, number.Render(planned))
+	}
+
 	doc.WriteString(
 		lipgloss.JoinHorizontal(lipgloss.Top,
 			lipgloss.JoinVertical(lipgloss.Left, rowLabels...),
@@ -153,6 +267,20 @@ func RenderStatsList(doc *strings.Builder, progress ProgressProvider, width int)
 	)
 }
 
+// formatBytes renders a byte count using binary units, e.g. "1.5 GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 func RenderHeader(doc *strings.Builder, props HeaderProps) {
 	headerStyle := headingStyle.Width(props.Width)
 	doc.WriteString("\n\n")